@@ -0,0 +1,201 @@
+package gitcliwrapper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+type VerifyArchiveOptions struct {
+	// Prefix is stripped from every path in the archive, such as the
+	// project-1.0/ directory release tarballs are usually wrapped in
+	Prefix string
+	// IgnoreModes only compares file content and symlink targets
+	IgnoreModes bool
+}
+
+type ArchiveFileDifference struct {
+	Path   string
+	Reason string
+}
+
+type ArchiveVerification struct {
+	Missing   []string
+	Extra     []string
+	Differing []ArchiveFileDifference
+}
+
+func (v ArchiveVerification) Matches() bool {
+	return len(v.Missing) == 0 && len(v.Extra) == 0 && len(v.Differing) == 0
+}
+
+type archiveEntry struct {
+	content    []byte
+	symlink    bool
+	executable bool
+	modeKnown  bool
+}
+
+func (git GitCLIWrapper) VerifyArchive(archivePath, ref string, opts VerifyArchiveOptions) (*ArchiveVerification, error) {
+	git.logger.Debugf("verifying archive %s against %s", archivePath, ref)
+
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		git.logger.Warnf("failed to read archive %s", archivePath)
+		return nil, err
+	}
+	actual, err := readArchiveEntries(data)
+	if err != nil {
+		git.logger.Warnf("failed to unpack archive %s", archivePath)
+		return nil, err
+	}
+	if opts.Prefix != "" {
+		prefix := strings.TrimSuffix(opts.Prefix, "/") + "/"
+		stripped := map[string]archiveEntry{}
+		for path, entry := range actual {
+			if !strings.HasPrefix(path, prefix) {
+				return nil, fmt.Errorf("archive entry %s is outside of the prefix %s", path, prefix)
+			}
+			stripped[strings.TrimPrefix(path, prefix)] = entry
+		}
+		actual = stripped
+	}
+
+	// git archive applies the export-ignore and export-subst attributes from
+	// the tree itself, so it is the reference for what a release should hold
+	expectedData, err := git.runGitRaw("archive", "--format=tar", ref)
+	if err != nil {
+		git.logger.Warnf("failed to create a reference archive for %s", ref)
+		return nil, err
+	}
+	expected, err := readTarEntries(bytes.NewReader(expectedData))
+	if err != nil {
+		return nil, err
+	}
+
+	verification := &ArchiveVerification{}
+	for path, want := range expected {
+		got, ok := actual[path]
+		if !ok {
+			verification.Missing = append(verification.Missing, path)
+			continue
+		}
+		switch {
+		case want.symlink != got.symlink:
+			verification.Differing = append(verification.Differing, ArchiveFileDifference{Path: path, Reason: "file type differs"})
+		case !bytes.Equal(want.content, got.content):
+			verification.Differing = append(verification.Differing, ArchiveFileDifference{Path: path, Reason: "content differs"})
+		case !opts.IgnoreModes && !want.symlink && got.modeKnown && want.executable != got.executable:
+			verification.Differing = append(verification.Differing, ArchiveFileDifference{Path: path, Reason: "executable bit differs"})
+		}
+	}
+	for path := range actual {
+		if _, ok := expected[path]; !ok {
+			verification.Extra = append(verification.Extra, path)
+		}
+	}
+
+	sort.Strings(verification.Missing)
+	sort.Strings(verification.Extra)
+	sort.Slice(verification.Differing, func(i, j int) bool {
+		return verification.Differing[i].Path < verification.Differing[j].Path
+	})
+
+	return verification, nil
+}
+
+func readArchiveEntries(data []byte) (map[string]archiveEntry, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return readZipEntries(data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readTarEntries(gz)
+	case bytes.HasPrefix(data, []byte("BZh")):
+		return readTarEntries(bzip2.NewReader(bytes.NewReader(data)))
+	default:
+		return readTarEntries(bytes.NewReader(data))
+	}
+}
+
+func readTarEntries(r io.Reader) (map[string]archiveEntry, error) {
+	entries := map[string]archiveEntry{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		path := strings.TrimPrefix(header.Name, "./")
+		switch header.Typeflag {
+		case tar.TypeReg:
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			entries[path] = archiveEntry{
+				content:    content,
+				executable: header.Mode&0o111 != 0,
+				modeKnown:  true,
+			}
+		case tar.TypeSymlink:
+			entries[path] = archiveEntry{
+				content:   []byte(header.Linkname),
+				symlink:   true,
+				modeKnown: true,
+			}
+		}
+	}
+}
+
+func readZipEntries(data []byte) (map[string]archiveEntry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]archiveEntry{}
+	for _, file := range zr.File {
+		mode := file.Mode()
+		if mode.IsDir() {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// zip files created off unix hosts carry no permission bits
+		const creatorUnix = 3
+		entries[strings.TrimPrefix(file.Name, "./")] = archiveEntry{
+			content:    content,
+			symlink:    mode&fs.ModeSymlink != 0,
+			executable: mode&0o111 != 0,
+			modeKnown:  file.CreatorVersion>>8 == creatorUnix,
+		}
+	}
+
+	return entries, nil
+}
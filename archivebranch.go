@@ -0,0 +1,257 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	archiveRefPrefix  = "refs/archive/"
+	archiveDateFormat = "2006-01-02"
+	archiveTimeFormat = "2006-01-02T150405Z"
+)
+
+type ArchivedBranch struct {
+	Name string
+	Date time.Time
+	Ref  string
+	Hash string
+	// Local and Remote tell where the archive ref exists
+	Local  bool
+	Remote bool
+}
+
+func parseArchiveRef(ref string) (string, time.Time, bool) {
+	if !strings.HasPrefix(ref, archiveRefPrefix) {
+		return "", time.Time{}, false
+	}
+	date, name, found := strings.Cut(strings.TrimPrefix(ref, archiveRefPrefix), "/")
+	if !found {
+		return "", time.Time{}, false
+	}
+	for _, format := range []string{archiveDateFormat, archiveTimeFormat} {
+		if parsed, err := time.Parse(format, date); err == nil {
+			return name, parsed, true
+		}
+	}
+	return "", time.Time{}, false
+}
+
+// ArchiveBranch moves a branch to refs/archive/<date>/<name>, both locally
+// and on the remote, before deleting it. When the branch was already
+// archived that day the time is added to the date to keep the refs apart.
+func (git GitCLIWrapper) ArchiveBranch(name string) (*ArchivedBranch, error) {
+	git.logger.Debugf("archiving branch %s", name)
+	branchRef := "refs/heads/" + name
+	date := time.Now().UTC()
+	archived := &ArchivedBranch{Name: name}
+
+	if current, err := git.runGit("symbolic-ref", "--quiet", "HEAD"); err == nil && current == branchRef {
+		return nil, fmt.Errorf("branch %s is checked out and can not be archived", name)
+	}
+
+	localHash, _ := git.runGit("rev-parse", "--verify", "--quiet", branchRef)
+	remoteHash := ""
+	if git.remote != "" {
+		var err error
+		if remoteHash, err = git.remoteRefHash(git.remote, branchRef); err != nil {
+			git.logger.Warnf("failed to look up %s on remote %s", name, git.remote)
+			return nil, err
+		}
+	}
+	if localHash == "" && remoteHash == "" {
+		return nil, fmt.Errorf("branch %s does not exist locally or on remote %s", name, git.remote)
+	}
+
+	for _, format := range []string{archiveDateFormat, archiveTimeFormat} {
+		ref := fmt.Sprintf("%s%s/%s", archiveRefPrefix, date.Format(format), name)
+		taken, err := git.archiveRefExists(ref)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			archived.Ref = ref
+			_, archived.Date, _ = parseArchiveRef(ref)
+			break
+		}
+	}
+	if archived.Ref == "" {
+		return nil, fmt.Errorf("branch %s was already archived at %s", name, date.Format(archiveTimeFormat))
+	}
+
+	if remoteHash != "" {
+		if _, err := git.runGit("cat-file", "-e", remoteHash+"^{commit}"); err != nil {
+			if _, err := git.runGit("fetch", "--quiet", git.remote, git.namespacedRef(branchRef)); err != nil {
+				git.logger.Warnf("failed to fetch %s from remote %s", name, git.remote)
+				return nil, err
+			}
+		}
+	}
+
+	// the local archive ref is created first, so a failure here leaves the
+	// remote untouched and a failed push can be rolled back locally
+	archiveHash := localHash
+	if archiveHash == "" {
+		archiveHash = remoteHash
+	}
+	if _, err := git.runGit("update-ref", "-m", "archive branch "+name, archived.Ref, archiveHash, ""); err != nil {
+		git.logger.Warnf("failed to create the local archive ref for %s", name)
+		return nil, err
+	}
+	archived.Local = true
+	archived.Hash = archiveHash
+
+	if remoteHash != "" {
+		err := git.push(git.remote, []string{remoteHash + ":" + archived.Ref, ":" + branchRef}, PushOptions{
+			Atomic: true,
+			Leases: map[string]string{branchRef: remoteHash, archived.Ref: ""},
+		})
+		if err != nil {
+			git.logger.Warnf("failed to archive %s on remote %s", name, git.remote)
+			if _, rollbackErr := git.runGit("update-ref", "-d", archived.Ref, archiveHash); rollbackErr != nil {
+				git.logger.Warnf("failed to remove the local archive ref %s", archived.Ref)
+			}
+			return nil, err
+		}
+		archived.Remote = true
+		archived.Hash = remoteHash
+	}
+
+	if localHash != "" {
+		if _, err := git.runGit("update-ref", "-d", branchRef, localHash); err != nil {
+			git.logger.Warnf("failed to delete the local branch %s", name)
+			return nil, err
+		}
+	}
+	if remoteHash != "" {
+		if _, err := git.runGit("update-ref", "-d", fmt.Sprintf("refs/remotes/%s/%s", git.remote, name)); err != nil {
+			git.logger.Debugf("no remote tracking ref to remove for %s", name)
+		}
+	}
+
+	return archived, nil
+}
+
+// archiveRefExists tells if an archive ref is taken locally or on the remote.
+func (git GitCLIWrapper) archiveRefExists(ref string) (bool, error) {
+	if hash, _ := git.runGit("rev-parse", "--verify", "--quiet", ref); hash != "" {
+		return true, nil
+	}
+	if git.remote == "" {
+		return false, nil
+	}
+	hash, err := git.remoteRefHash(git.remote, ref)
+	if err != nil {
+		git.logger.Warnf("failed to look up %s on remote %s", ref, git.remote)
+		return false, err
+	}
+	return hash != "", nil
+}
+
+func (git GitCLIWrapper) ListArchived() ([]ArchivedBranch, error) {
+	git.logger.Debug("listing archived branches")
+	byRef := map[string]*ArchivedBranch{}
+
+	stdOut, err := git.runGit("for-each-ref", "--format=%(objectname) %(refname)", archiveRefPrefix)
+	if err != nil {
+		git.logger.Warn("failed to list local archived branches")
+		return nil, err
+	}
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if name, date, ok := parseArchiveRef(fields[1]); ok {
+			byRef[fields[1]] = &ArchivedBranch{Name: name, Date: date, Ref: fields[1], Hash: fields[0], Local: true}
+		}
+	}
+
+	if git.remote != "" {
+		stdOut, err := git.runGit("ls-remote", git.remote, git.namespacedRef(archiveRefPrefix)+"*")
+		if err != nil {
+			git.logger.Warnf("failed to list archived branches on remote %s", git.remote)
+			return nil, err
+		}
+		for _, line := range strings.Split(stdOut, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			ref := git.stripNamespace(fields[1])
+			name, date, ok := parseArchiveRef(ref)
+			if !ok {
+				continue
+			}
+			if archived, exists := byRef[ref]; exists {
+				archived.Remote = true
+				continue
+			}
+			byRef[ref] = &ArchivedBranch{Name: name, Date: date, Ref: ref, Hash: fields[0], Remote: true}
+		}
+	}
+
+	archivedBranches := make([]ArchivedBranch, 0, len(byRef))
+	for _, archived := range byRef {
+		archivedBranches = append(archivedBranches, *archived)
+	}
+	sort.Slice(archivedBranches, func(i, j int) bool {
+		if !archivedBranches[i].Date.Equal(archivedBranches[j].Date) {
+			return archivedBranches[i].Date.After(archivedBranches[j].Date)
+		}
+		return archivedBranches[i].Name < archivedBranches[j].Name
+	})
+
+	return archivedBranches, nil
+}
+
+// RestoreBranch recreates a branch from its most recent archive, locally and
+// on the remote. The archive ref is kept for auditing.
+func (git GitCLIWrapper) RestoreBranch(name string) (*ArchivedBranch, error) {
+	git.logger.Debugf("restoring archived branch %s", name)
+	archivedBranches, err := git.ListArchived()
+	if err != nil {
+		return nil, err
+	}
+
+	var archived *ArchivedBranch
+	for idx := range archivedBranches {
+		if archivedBranches[idx].Name == name {
+			archived = &archivedBranches[idx]
+			break
+		}
+	}
+	if archived == nil {
+		return nil, fmt.Errorf("no archive found for branch %s", name)
+	}
+
+	branchRef := "refs/heads/" + name
+	if existing, _ := git.runGit("rev-parse", "--verify", "--quiet", branchRef); existing != "" {
+		return nil, fmt.Errorf("branch %s already exists", name)
+	}
+	if !archived.Local {
+		if _, err := git.runGit("fetch", "--quiet", git.remote, git.namespacedRef(archived.Ref)+":"+archived.Ref); err != nil {
+			git.logger.Warnf("failed to fetch archive %s from remote %s", archived.Ref, git.remote)
+			return nil, err
+		}
+	}
+
+	if git.remote != "" {
+		err := git.push(git.remote, []string{archived.Hash + ":" + branchRef}, PushOptions{
+			Leases: map[string]string{branchRef: ""},
+		})
+		if err != nil {
+			git.logger.Warnf("failed to restore %s on remote %s", name, git.remote)
+			return nil, err
+		}
+	}
+
+	if _, err := git.runGit("update-ref", "-m", "restore archived branch "+name, branchRef, archived.Hash, ""); err != nil {
+		git.logger.Warnf("failed to restore the local branch %s", name)
+		return nil, err
+	}
+
+	return archived, nil
+}
@@ -0,0 +1,49 @@
+package gitcliwrapper
+
+import (
+	"testing"
+)
+
+func TestArchiveBranchTwiceInADay(t *testing.T) {
+	remoteDir := t.TempDir()
+	runTestGit(t, remoteDir, "init", "--quiet", "--bare")
+	git, dir := newTestRepository(t)
+	runTestGit(t, dir, "remote", "add", "origin", remoteDir)
+	runTestGit(t, dir, "push", "--quiet", "origin", "main")
+	git.remote = "origin"
+
+	var refs []string
+	for _, message := range []string{"first", "second"} {
+		runTestGit(t, dir, "branch", "feat", "main")
+		runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", message)
+		runTestGit(t, dir, "push", "--quiet", "origin", "HEAD:refs/heads/feat")
+		runTestGit(t, dir, "update-ref", "refs/heads/feat", "HEAD")
+
+		archived, err := git.ArchiveBranch("feat")
+		if err != nil {
+			t.Fatalf("ArchiveBranch: %s", err)
+		}
+		if !archived.Local || !archived.Remote {
+			t.Fatalf("expected %s to be archived locally and on the remote", archived.Ref)
+		}
+		refs = append(refs, archived.Ref)
+
+		if hash := runTestGit(t, remoteDir, "rev-parse", archived.Ref); hash != archived.Hash {
+			t.Fatalf("expected %s on the remote to be %s, got %s", archived.Ref, archived.Hash, hash)
+		}
+		if branches := runTestGit(t, dir, "branch", "--list", "feat"); branches != "" {
+			t.Fatalf("expected the local branch to be deleted, got %s", branches)
+		}
+	}
+	if refs[0] == refs[1] {
+		t.Fatalf("expected distinct archive refs, got %s twice", refs[0])
+	}
+
+	archived, err := git.ListArchived()
+	if err != nil {
+		t.Fatalf("ListArchived: %s", err)
+	}
+	if len(archived) != 2 || archived[0].Ref != refs[1] {
+		t.Fatalf("expected the second archive to be listed first, got %v", archived)
+	}
+}
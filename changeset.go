@@ -0,0 +1,161 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ChangeSetUnchanged      = "unchanged"
+	ChangeSetPushed         = "pushed"
+	ChangeSetFailed         = "failed"
+	ChangeSetSkipped        = "skipped"
+	ChangeSetRolledBack     = "rolled_back"
+	ChangeSetRollbackFailed = "rollback_failed"
+)
+
+// ChangeSet applies a change under the same topic branch across several
+// repositories, and pushes them so that either all of them land or the ones
+// already pushed are rolled back.
+type ChangeSet struct {
+	Topic string
+	repos []*changeSetRepo
+}
+
+type changeSetRepo struct {
+	git       *GitCLIWrapper
+	committed string
+	result    ChangeSetResult
+}
+
+type ChangeSetResult struct {
+	Directory string
+	Remote    string
+	Ref       string
+	// Previous is the remote hash before the push, empty if the topic
+	// branch did not exist
+	Previous string
+	Pushed   string
+	Status   string
+	Err      error
+}
+
+func NewChangeSet(topic string, repos ...*GitCLIWrapper) *ChangeSet {
+	changeSet := &ChangeSet{Topic: topic}
+	for _, git := range repos {
+		changeSet.repos = append(changeSet.repos, &changeSetRepo{
+			git: git,
+			result: ChangeSetResult{
+				Directory: git.cmd.Dir,
+				Remote:    git.remote,
+				Ref:       "refs/heads/" + topic,
+				Status:    ChangeSetUnchanged,
+			},
+		})
+	}
+	return changeSet
+}
+
+func (changeSet *ChangeSet) CreateBranches(startPoint string) error {
+	for _, repo := range changeSet.repos {
+		if err := repo.git.CheckoutNewBranch(changeSet.Topic, startPoint); err != nil {
+			return fmt.Errorf("failed to create %s in %s: %w", changeSet.Topic, repo.git.cmd.Dir, err)
+		}
+	}
+	return nil
+}
+
+// Commit commits pending changes in every repository, repositories without
+// changes are left out of the push.
+func (changeSet *ChangeSet) Commit(message string) error {
+	for _, repo := range changeSet.repos {
+		hash, err := repo.git.Commit(message)
+		if errors.Is(err, ErrNothingToCommit) {
+			repo.git.logger.Infof("no changes to commit in %s", repo.git.cmd.Dir)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to commit in %s: %w", repo.git.cmd.Dir, err)
+		}
+		repo.committed = *hash
+	}
+	return nil
+}
+
+func (changeSet *ChangeSet) Push() ([]ChangeSetResult, error) {
+	var pushed []*changeSetRepo
+	var pushErr error
+
+	for _, repo := range changeSet.repos {
+		if repo.committed == "" {
+			continue
+		}
+		if pushErr != nil {
+			repo.result.Status = ChangeSetSkipped
+			continue
+		}
+
+		git := repo.git
+		previous, err := git.remoteRefHash(git.remote, repo.result.Ref)
+		if err == nil && previous != "" {
+			// the previous commit has to exist locally to be able to roll back
+			_, err = git.runGit("fetch", "--quiet", git.remote, git.namespacedRef(repo.result.Ref))
+			if err == nil {
+				if _, ancestorErr := git.runGit("merge-base", "--is-ancestor", previous, repo.committed); ancestorErr != nil {
+					err = fmt.Errorf("remote %s has diverged from the change set", repo.result.Ref)
+				}
+			}
+		}
+		if err == nil {
+			repo.result.Previous = previous
+			err = git.push(git.remote, []string{repo.committed + ":" + repo.result.Ref}, PushOptions{
+				Leases: map[string]string{repo.result.Ref: previous},
+			})
+		}
+		if err != nil {
+			git.logger.Warnf("failed to push %s in %s, rolling back the change set", changeSet.Topic, git.cmd.Dir)
+			repo.result.Status = ChangeSetFailed
+			repo.result.Err = err
+			pushErr = fmt.Errorf("failed to push %s in %s: %w", changeSet.Topic, git.cmd.Dir, err)
+			continue
+		}
+
+		repo.result.Pushed = repo.committed
+		repo.result.Status = ChangeSetPushed
+		pushed = append(pushed, repo)
+	}
+
+	if pushErr != nil {
+		for idx := len(pushed) - 1; idx >= 0; idx-- {
+			pushed[idx].rollback()
+		}
+	}
+
+	results := make([]ChangeSetResult, 0, len(changeSet.repos))
+	for _, repo := range changeSet.repos {
+		results = append(results, repo.result)
+	}
+	return results, pushErr
+}
+
+func (repo *changeSetRepo) rollback() {
+	git := repo.git
+	git.logger.Infof("rolling back %s in %s", repo.result.Ref, git.cmd.Dir)
+
+	refSpec := repo.result.Previous + ":" + repo.result.Ref
+	if repo.result.Previous == "" {
+		refSpec = ":" + repo.result.Ref
+	}
+	// the lease alone allows rewinding or deleting the branch, and only
+	// while it is still at what was pushed
+	err := git.push(git.remote, []string{refSpec}, PushOptions{
+		Leases: map[string]string{repo.result.Ref: repo.result.Pushed},
+	})
+	if err != nil {
+		git.logger.Errorf("failed to roll back %s in %s", repo.result.Ref, git.cmd.Dir)
+		repo.result.Status = ChangeSetRollbackFailed
+		repo.result.Err = err
+		return
+	}
+	repo.result.Status = ChangeSetRolledBack
+}
@@ -0,0 +1,58 @@
+package gitcliwrapper
+
+import (
+	"testing"
+)
+
+func TestChangeSetRollbackRespectsRemoteMoves(t *testing.T) {
+	tests := []struct {
+		name     string
+		moved    bool
+		expected string
+	}{
+		{"untouched", false, ChangeSetRolledBack},
+		{"moved", true, ChangeSetRollbackFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remoteDir, otherDir := newTestRemote(t)
+			git, dir := newTestRepository(t)
+			runTestGit(t, dir, "remote", "add", "origin", remoteDir)
+			runTestGit(t, dir, "fetch", "--quiet", "origin")
+			runTestGit(t, dir, "reset", "--quiet", "--hard", "origin/main")
+			git.remote = "origin"
+
+			runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "change set")
+			pushed := runTestGit(t, dir, "rev-parse", "HEAD")
+			runTestGit(t, dir, "push", "--quiet", "origin", "HEAD:refs/heads/topic")
+
+			expectedRemote := ""
+			if test.moved {
+				runTestGit(t, otherDir, "fetch", "--quiet", remoteDir, "refs/heads/topic")
+				runTestGit(t, otherDir, "reset", "--quiet", "--hard", "FETCH_HEAD")
+				runTestGit(t, otherDir, "commit", "--quiet", "--allow-empty", "-m", "pushed meanwhile")
+				runTestGit(t, otherDir, "push", "--quiet", remoteDir, "HEAD:refs/heads/topic")
+				expectedRemote = runTestGit(t, otherDir, "rev-parse", "HEAD")
+			}
+
+			repo := &changeSetRepo{
+				git:       git,
+				committed: pushed,
+				result:    ChangeSetResult{Ref: "refs/heads/topic", Pushed: pushed, Status: ChangeSetPushed},
+			}
+			repo.rollback()
+			if repo.result.Status != test.expected {
+				t.Fatalf("expected %s, got %s: %v", test.expected, repo.result.Status, repo.result.Err)
+			}
+
+			remoteHash, err := git.remoteRefHash("origin", "refs/heads/topic")
+			if err != nil {
+				t.Fatalf("remoteRefHash: %s", err)
+			}
+			if remoteHash != expectedRemote {
+				t.Fatalf("expected the remote topic to be %q, got %q", expectedRemote, remoteHash)
+			}
+		})
+	}
+}
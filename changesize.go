@@ -0,0 +1,243 @@
+package gitcliwrapper
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultDominantFiles = 5
+
+type SizeBucket struct {
+	Name     string
+	MaxLines int
+	// MaxFiles is ignored when zero
+	MaxFiles int
+}
+
+var DefaultSizeBuckets = []SizeBucket{
+	{Name: "XS", MaxLines: 10},
+	{Name: "S", MaxLines: 30},
+	{Name: "M", MaxLines: 100},
+	{Name: "L", MaxLines: 500},
+	{Name: "XL", MaxLines: 1000},
+}
+
+const largestSizeBucket = "XXL"
+
+type ChangeSizeRules struct {
+	// Buckets are checked in order, a change larger than all of them is XXL.
+	// DefaultSizeBuckets is used when empty.
+	Buckets []SizeBucket
+	// Exclude are globs for paths left out of the count, ** matches across
+	// directories and patterns without a slash match the file name
+	Exclude []string
+	// DominantFiles is how many of the largest files are reported, defaults to 5
+	DominantFiles int
+}
+
+type FileChange struct {
+	Path    string
+	Added   int
+	Deleted int
+	Binary  bool
+	// Share is the fraction of the counted lines this file accounts for
+	Share float64
+}
+
+type ExcludedFile struct {
+	Path   string
+	Reason string
+}
+
+type ChangeSize struct {
+	Bucket   string
+	Lines    int
+	Added    int
+	Deleted  int
+	Files    int
+	Dominant []FileChange
+	Excluded []ExcludedFile
+}
+
+func globToRegexp(pattern string) *regexp.Regexp {
+	sb := strings.Builder{}
+	sb.WriteString("^")
+	for idx := 0; idx < len(pattern); idx++ {
+		switch char := pattern[idx]; char {
+		case '*':
+			if idx+1 < len(pattern) && pattern[idx+1] == '*' {
+				idx++
+				if idx+1 < len(pattern) && pattern[idx+1] == '/' {
+					idx++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+func matchesPathGlob(filePath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(filePath)); matched {
+				return true
+			}
+			continue
+		}
+		if globToRegexp(strings.TrimPrefix(pattern, "/")).MatchString(filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// changeSizeAttributes reads the attributes which mark a path as not worth
+// reviewing, using the .gitattributes files committed at rev.
+func (git GitCLIWrapper) changeSizeAttributes(rev string, paths []string) (map[string]string, error) {
+	reasons := map[string]string{}
+	if len(paths) == 0 {
+		return reasons, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gitcliwrapper-index-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	indexEnv := []string{"GIT_INDEX_FILE=" + filepath.Join(tmpDir, "index")}
+
+	if _, err := git.runGitInvocation(gitInvocation{args: []string{"read-tree", rev}, env: indexEnv}); err != nil {
+		git.logger.Warnf("failed to read %s into a temporary index", rev)
+		return nil, err
+	}
+
+	stdOut, err := git.runGitInvocation(gitInvocation{
+		args:  []string{"check-attr", "--cached", "-z", "--stdin", "linguist-generated", "linguist-vendored", "diff"},
+		env:   indexEnv,
+		stdin: strings.NewReader(strings.Join(paths, "\x00") + "\x00"),
+	})
+	if err != nil {
+		git.logger.Warn("failed to check attributes")
+		return nil, err
+	}
+
+	fields := strings.Split(string(stdOut), "\x00")
+	for idx := 0; idx+2 < len(fields); idx += 3 {
+		filePath, attribute, value := fields[idx], fields[idx+1], fields[idx+2]
+		switch {
+		case attribute == "linguist-generated" && (value == "set" || value == "true"):
+			reasons[filePath] = "generated"
+		case attribute == "linguist-vendored" && (value == "set" || value == "true"):
+			reasons[filePath] = "vendored"
+		case attribute == "diff" && value == "unset":
+			if _, ok := reasons[filePath]; !ok {
+				reasons[filePath] = "-diff"
+			}
+		}
+	}
+
+	return reasons, nil
+}
+
+func (git GitCLIWrapper) ClassifyChangeSize(base, head string, rules ChangeSizeRules) (*ChangeSize, error) {
+	git.logger.Debugf("classifying change size between %s and %s", base, head)
+
+	stdOut, err := git.runGitRaw("diff", "--numstat", "-z", "-M", base+"..."+head)
+	if err != nil {
+		git.logger.Warnf("failed to diff %s and %s", base, head)
+		return nil, err
+	}
+
+	var changes []FileChange
+	fields := strings.Split(string(stdOut), "\x00")
+	for idx := 0; idx < len(fields); idx++ {
+		stat := strings.SplitN(fields[idx], "\t", 3)
+		if len(stat) != 3 {
+			continue
+		}
+		change := FileChange{Path: stat[2]}
+		if stat[2] == "" && idx+2 < len(fields) {
+			// renames are followed by the old and new path
+			change.Path = fields[idx+2]
+			idx += 2
+		}
+		if stat[0] == "-" {
+			change.Binary = true
+		} else {
+			change.Added, _ = strconv.Atoi(stat[0])
+			change.Deleted, _ = strconv.Atoi(stat[1])
+		}
+		changes = append(changes, change)
+	}
+
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+	attributeReasons, err := git.changeSizeAttributes(head, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	size := &ChangeSize{}
+	var counted []FileChange
+	for _, change := range changes {
+		if reason, ok := attributeReasons[change.Path]; ok {
+			size.Excluded = append(size.Excluded, ExcludedFile{Path: change.Path, Reason: reason})
+			continue
+		}
+		if matchesPathGlob(change.Path, rules.Exclude) {
+			size.Excluded = append(size.Excluded, ExcludedFile{Path: change.Path, Reason: "excluded by rule"})
+			continue
+		}
+		counted = append(counted, change)
+		size.Added += change.Added
+		size.Deleted += change.Deleted
+	}
+	size.Lines = size.Added + size.Deleted
+	size.Files = len(counted)
+
+	buckets := rules.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultSizeBuckets
+	}
+	size.Bucket = largestSizeBucket
+	for _, bucket := range buckets {
+		if size.Lines <= bucket.MaxLines && (bucket.MaxFiles == 0 || size.Files <= bucket.MaxFiles) {
+			size.Bucket = bucket.Name
+			break
+		}
+	}
+
+	sort.SliceStable(counted, func(i, j int) bool {
+		return counted[i].Added+counted[i].Deleted > counted[j].Added+counted[j].Deleted
+	})
+	dominantFiles := rules.DominantFiles
+	if dominantFiles <= 0 {
+		dominantFiles = defaultDominantFiles
+	}
+	for idx := 0; idx < len(counted) && idx < dominantFiles; idx++ {
+		change := counted[idx]
+		if size.Lines > 0 {
+			change.Share = float64(change.Added+change.Deleted) / float64(size.Lines)
+		}
+		size.Dominant = append(size.Dominant, change)
+	}
+
+	return size, nil
+}
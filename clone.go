@@ -0,0 +1,197 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marmotherder/go-cmdwrapper"
+)
+
+type CloneOptions struct {
+	Branch string
+	Depth  int
+	Bare   bool
+	// Remote is the name given to the cloned remote, defaults to origin
+	Remote string
+	// Cache borrows objects from a local mirror of the remote, the clone
+	// still works without it if the mirror can not be refreshed
+	Cache *CloneCache
+	// Dissociate copies borrowed objects into the clone, so it keeps working
+	// if the cache is removed
+	Dissociate bool
+}
+
+func Clone(remoteURL, directory string, l logger, opts CloneOptions) (*GitCLIWrapper, error) {
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, err
+	}
+	parent := filepath.Dir(absDirectory)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, err
+	}
+
+	runner := GitCLIWrapper{
+		logger: l,
+		cmd: cmdwrapper.CMDWrapper{
+			Dir:    parent,
+			Logger: l,
+		},
+	}
+	runner.logger.Debugf("cloning %s into %s", stripCredentials(remoteURL), absDirectory)
+
+	remote := opts.Remote
+	if remote == "" {
+		remote = "origin"
+	}
+
+	args := []string{"clone", "--quiet", "--origin", remote}
+	if opts.Cache != nil {
+		mirrorPath, err := opts.Cache.Refresh(remoteURL)
+		if err != nil {
+			runner.logger.Warnf("clone cache is unavailable, cloning %s directly", stripCredentials(remoteURL))
+		} else {
+			args = append(args, "--reference-if-able", mirrorPath)
+			if opts.Dissociate {
+				args = append(args, "--dissociate")
+			}
+		}
+	}
+	if opts.Branch != "" {
+		args = append(args, "--branch", opts.Branch)
+	}
+	if opts.Depth > 0 {
+		args = append(args, "--depth", fmt.Sprintf("%d", opts.Depth))
+	}
+	if opts.Bare {
+		args = append(args, "--bare")
+	}
+	args = append(args, remoteURL, absDirectory)
+
+	if _, err := runner.runGit(args...); err != nil {
+		runner.logger.Warnf("failed to clone %s", stripCredentials(remoteURL))
+		return nil, err
+	}
+
+	return NewGitCLIWrapper(absDirectory, l, remote)
+}
+
+func (git GitCLIWrapper) Alternates() ([]string, error) {
+	path, err := git.alternatesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var alternates []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		alternates = append(alternates, line)
+	}
+	return alternates, nil
+}
+
+func (git GitCLIWrapper) writeAlternates(alternates []string) error {
+	path, err := git.alternatesPath()
+	if err != nil {
+		return err
+	}
+	if len(alternates) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.Join(alternates, "\n")+"\n"), 0o644)
+}
+
+// alternateObjectsPath turns a work tree, bare repository or objects
+// directory into the absolute objects path stored in the alternates file.
+func alternateObjectsPath(repository string) (string, error) {
+	objectsPath, err := filepath.Abs(repository)
+	if err != nil {
+		return "", err
+	}
+	for _, candidate := range []string{filepath.Join(objectsPath, ".git", "objects"), filepath.Join(objectsPath, "objects")} {
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate, nil
+		}
+	}
+	return objectsPath, nil
+}
+
+// AddAlternate borrows objects from another repository, which can be given
+// as a work tree, a bare repository or an objects directory.
+func (git GitCLIWrapper) AddAlternate(repository string) error {
+	git.logger.Debugf("adding object alternate %s", repository)
+	objectsPath, err := alternateObjectsPath(repository)
+	if err != nil {
+		return err
+	}
+
+	alternates, err := git.Alternates()
+	if err != nil {
+		return err
+	}
+	for _, alternate := range alternates {
+		if alternate == objectsPath {
+			return nil
+		}
+	}
+	return git.writeAlternates(append(alternates, objectsPath))
+}
+
+// RemoveAlternate copies every borrowed object into the repository before
+// dropping the alternate, so nothing reachable is lost. The repository is
+// given the same way as to AddAlternate.
+func (git GitCLIWrapper) RemoveAlternate(repository string) error {
+	git.logger.Debugf("removing object alternate %s", repository)
+	objectsPath, err := alternateObjectsPath(repository)
+	if err != nil {
+		return err
+	}
+	alternates, err := git.Alternates()
+	if err != nil {
+		return err
+	}
+
+	var remaining []string
+	for _, alternate := range alternates {
+		if alternate != objectsPath && alternate != repository {
+			remaining = append(remaining, alternate)
+		}
+	}
+	if len(remaining) == len(alternates) {
+		return fmt.Errorf("%s is not an alternate of this repository", repository)
+	}
+
+	if _, err := git.runGit("repack", "-a", "-d", "--quiet"); err != nil {
+		git.logger.Warn("failed to repack before removing the alternate")
+		return err
+	}
+	return git.writeAlternates(remaining)
+}
+
+func (git GitCLIWrapper) Dissociate() error {
+	git.logger.Debug("dissociating the repository from its alternates")
+	if _, err := git.runGit("repack", "-a", "-d", "--quiet"); err != nil {
+		git.logger.Warn("failed to repack before dissociating")
+		return err
+	}
+	return git.writeAlternates(nil)
+}
@@ -0,0 +1,28 @@
+package gitcliwrapper
+
+import (
+	"testing"
+)
+
+func TestAddAndRemoveAlternate(t *testing.T) {
+	remoteDir, _ := newTestRemote(t)
+	git, _ := newTestRepository(t)
+
+	if err := git.AddAlternate(remoteDir); err != nil {
+		t.Fatalf("AddAlternate: %s", err)
+	}
+	alternates, err := git.Alternates()
+	if err != nil {
+		t.Fatalf("Alternates: %s", err)
+	}
+	if len(alternates) != 1 {
+		t.Fatalf("expected one alternate, got %v", alternates)
+	}
+
+	if err := git.RemoveAlternate(remoteDir); err != nil {
+		t.Fatalf("RemoveAlternate: %s", err)
+	}
+	if alternates, err = git.Alternates(); err != nil || len(alternates) != 0 {
+		t.Fatalf("expected no alternates, got %v, %v", alternates, err)
+	}
+}
@@ -0,0 +1,225 @@
+package gitcliwrapper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/marmotherder/go-cmdwrapper"
+)
+
+const (
+	defaultCloneCacheLockTimeout = 10 * time.Minute
+	defaultCloneCacheStaleLock   = 30 * time.Minute
+	cloneCacheLockPoll           = 250 * time.Millisecond
+)
+
+// CloneCache keeps a bare mirror per remote URL, which clones borrow objects
+// from so only new objects have to be transferred.
+type CloneCache struct {
+	Dir string
+	// LockTimeout is how long to wait for another process refreshing the
+	// same mirror, defaults to 10m
+	LockTimeout time.Duration
+	// StaleLockAge is when a leftover lock file is considered abandoned,
+	// defaults to 30m
+	StaleLockAge time.Duration
+
+	git GitCLIWrapper
+}
+
+func NewCloneCache(dir string, l logger) (*CloneCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CloneCache{
+		Dir: absDir,
+		git: GitCLIWrapper{
+			logger: l,
+			cmd: cmdwrapper.CMDWrapper{
+				Dir:    absDir,
+				Logger: l,
+			},
+		},
+	}, nil
+}
+
+func (cache *CloneCache) MirrorPath(remoteURL string) string {
+	sum := sha256.Sum256([]byte(stripCredentials(remoteURL)))
+	return filepath.Join(cache.Dir, hex.EncodeToString(sum[:])[:24]+".git")
+}
+
+func (cache *CloneCache) lock(mirrorPath string) (func(), error) {
+	timeout := cache.LockTimeout
+	if timeout == 0 {
+		timeout = defaultCloneCacheLockTimeout
+	}
+	staleAge := cache.StaleLockAge
+	if staleAge == 0 {
+		staleAge = defaultCloneCacheStaleLock
+	}
+
+	lockPath := mirrorPath + ".lock"
+	// the owner is written to the lock, so it can tell its own lock apart
+	// from one which replaced it
+	owner := fmt.Sprintf("%d %d\n", os.Getpid(), time.Now().UnixNano())
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, err = file.WriteString(owner)
+			file.Close()
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			return cache.holdLock(lockPath, owner, staleAge), nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if cache.breakStaleLock(lockPath, staleAge) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for clone cache lock %s", lockPath)
+		}
+		time.Sleep(cloneCacheLockPoll)
+	}
+}
+
+// holdLock keeps touching the lock while it is held, so a long first clone
+// is not mistaken for an abandoned one, and returns the unlock function.
+func (cache *CloneCache) holdLock(lockPath, owner string, staleAge time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(staleAge / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if content, err := os.ReadFile(lockPath); err != nil || string(content) != owner {
+					cache.git.logger.Warnf("clone cache lock %s was taken over", lockPath)
+					return
+				}
+				now := time.Now()
+				if err := os.Chtimes(lockPath, now, now); err != nil {
+					cache.git.logger.Warnf("failed to refresh clone cache lock %s", lockPath)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		if content, err := os.ReadFile(lockPath); err != nil || string(content) != owner {
+			cache.git.logger.Warnf("clone cache lock %s is no longer held, leaving it", lockPath)
+			return
+		}
+		if err := os.Remove(lockPath); err != nil {
+			cache.git.logger.Warnf("failed to remove clone cache lock %s", lockPath)
+		}
+	}
+}
+
+// breakStaleLock removes a lock which has not been touched for staleAge. The
+// lock is first moved aside and only removed when it is still the one found
+// stale, otherwise it is put back.
+func (cache *CloneCache) breakStaleLock(lockPath string, staleAge time.Duration) bool {
+	info, err := os.Stat(lockPath)
+	if err != nil || time.Since(info.ModTime()) <= staleAge {
+		return false
+	}
+	owner, err := os.ReadFile(lockPath)
+	if err != nil {
+		return false
+	}
+
+	movedPath := fmt.Sprintf("%s.stale.%d.%d", lockPath, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(lockPath, movedPath); err != nil {
+		return false
+	}
+	movedInfo, statErr := os.Stat(movedPath)
+	movedOwner, readErr := os.ReadFile(movedPath)
+	if statErr == nil && readErr == nil && movedInfo.ModTime().Equal(info.ModTime()) && string(movedOwner) == string(owner) {
+		cache.git.logger.Warnf("removing stale clone cache lock %s", lockPath)
+		os.Remove(movedPath)
+		return true
+	}
+
+	// another process took the lock in the meantime, a hard link puts it
+	// back without replacing a lock created since
+	if err := os.Link(movedPath, lockPath); err != nil {
+		cache.git.logger.Warnf("failed to put back clone cache lock %s", lockPath)
+	}
+	os.Remove(movedPath)
+	return false
+}
+
+// Refresh creates or updates the mirror for a remote and returns its path.
+func (cache *CloneCache) Refresh(remoteURL string) (string, error) {
+	mirrorPath := cache.MirrorPath(remoteURL)
+	cache.git.logger.Debugf("refreshing clone cache mirror %s for %s", mirrorPath, stripCredentials(remoteURL))
+
+	unlock, err := cache.lock(mirrorPath)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(mirrorPath); errors.Is(err, os.ErrNotExist) {
+		tmpPath := mirrorPath + ".tmp"
+		os.RemoveAll(tmpPath)
+		if _, err := cache.git.runGit("clone", "--mirror", "--quiet", remoteURL, tmpPath); err != nil {
+			cache.git.logger.Warnf("failed to create clone cache mirror for %s", stripCredentials(remoteURL))
+			os.RemoveAll(tmpPath)
+			return "", err
+		}
+		// clones borrowing from the mirror rely on its objects, so they
+		// must never be pruned away
+		for _, setting := range [][]string{{"gc.pruneExpire", "never"}, {"gc.auto", "0"}} {
+			if _, err := cache.git.runGit("-C", tmpPath, "config", setting[0], setting[1]); err != nil {
+				os.RemoveAll(tmpPath)
+				return "", err
+			}
+		}
+		if err := os.Rename(tmpPath, mirrorPath); err != nil {
+			os.RemoveAll(tmpPath)
+			return "", err
+		}
+		return mirrorPath, nil
+	}
+
+	if _, err := cache.git.runGit("-C", mirrorPath, "fetch", "--prune", "--quiet", "origin"); err != nil {
+		cache.git.logger.Warnf("failed to refresh clone cache mirror for %s", stripCredentials(remoteURL))
+		return "", err
+	}
+
+	return mirrorPath, nil
+}
+
+func (git GitCLIWrapper) alternatesPath() (string, error) {
+	path, err := git.runGit("rev-parse", "--git-path", "objects/info/alternates")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(git.cmd.Dir, path)
+	}
+	return path, nil
+}
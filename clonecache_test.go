@@ -0,0 +1,114 @@
+package gitcliwrapper
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRemote(t *testing.T) (string, string) {
+	t.Helper()
+	remoteDir := t.TempDir()
+	runTestGit(t, remoteDir, "init", "--quiet", "--bare")
+	_, dir := newTestRepository(t)
+	runTestGit(t, dir, "push", "--quiet", remoteDir, "main")
+	return remoteDir, dir
+}
+
+func TestCloneCacheRefresh(t *testing.T) {
+	remoteDir, dir := newTestRemote(t)
+	cache, err := NewCloneCache(t.TempDir(), testLogger{t})
+	if err != nil {
+		t.Fatalf("NewCloneCache: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for idx := 0; idx < cap(errs); idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.Refresh(remoteDir)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent Refresh: %s", err)
+		}
+	}
+
+	runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "second")
+	runTestGit(t, dir, "push", "--quiet", remoteDir, "main")
+	mirrorPath, err := cache.Refresh(remoteDir)
+	if err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	if hash := runTestGit(t, mirrorPath, "rev-parse", "main"); hash != runTestGit(t, dir, "rev-parse", "main") {
+		t.Fatalf("expected the mirror to be refreshed to the new commit, got %s", hash)
+	}
+	if _, err := os.Stat(mirrorPath + ".lock"); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock to be released, got %v", err)
+	}
+}
+
+func TestCloneCacheLockIsKeptAlive(t *testing.T) {
+	cache, err := NewCloneCache(t.TempDir(), testLogger{t})
+	if err != nil {
+		t.Fatalf("NewCloneCache: %s", err)
+	}
+	cache.StaleLockAge = 300 * time.Millisecond
+	cache.LockTimeout = time.Second
+	mirrorPath := filepath.Join(cache.Dir, "mirror.git")
+
+	unlock, err := cache.lock(mirrorPath)
+	if err != nil {
+		t.Fatalf("lock: %s", err)
+	}
+	// held for longer than the stale age, the lock must not be broken
+	if _, err := cache.lock(mirrorPath); err == nil {
+		t.Fatal("expected a held lock to not be taken over")
+	}
+	unlock()
+
+	unlock, err = cache.lock(mirrorPath)
+	if err != nil {
+		t.Fatalf("expected the released lock to be free: %s", err)
+	}
+	unlock()
+}
+
+func TestCloneCacheBreaksStaleLock(t *testing.T) {
+	cache, err := NewCloneCache(t.TempDir(), testLogger{t})
+	if err != nil {
+		t.Fatalf("NewCloneCache: %s", err)
+	}
+	cache.StaleLockAge = time.Minute
+	cache.LockTimeout = time.Second
+	mirrorPath := filepath.Join(cache.Dir, "mirror.git")
+
+	lockPath := mirrorPath + ".lock"
+	if err := os.WriteFile(lockPath, []byte("1 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	abandoned := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lockPath, abandoned, abandoned); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := cache.lock(mirrorPath)
+	if err != nil {
+		t.Fatalf("expected the stale lock to be broken: %s", err)
+	}
+	if content, _ := os.ReadFile(lockPath); string(content) == "1 1\n" {
+		t.Fatal("expected the lock to be replaced")
+	}
+	unlock()
+	if matches, _ := filepath.Glob(lockPath + ".stale.*"); len(matches) > 0 {
+		t.Fatalf("expected no leftover stale locks, got %v", matches)
+	}
+}
@@ -0,0 +1,141 @@
+package gitcliwrapper
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// gitInvocation describes a single git call made through runGitInvocation.
+// Calls are made with os/exec rather than the cmdwrapper runner, as it can
+// not feed stdin or extra environment to a command and can drop the output
+// of short lived processes.
+type gitInvocation struct {
+	args  []string
+	stdin io.Reader
+	env   []string
+}
+
+// CommandError is returned when git exits with a non zero code, it keeps the
+// stderr output so callers can tell different failures apart.
+type CommandError struct {
+	Subcommand string
+	ExitCode   int
+	Stderr     string
+	// Performance is set when trace2 instrumentation is enabled
+	Performance *PerformanceSummary
+}
+
+func (e *CommandError) Error() string {
+	return nonZeroCode(e.Subcommand).Error()
+}
+
+func gitSubcommand(args []string) string {
+	for idx := 0; idx < len(args); idx++ {
+		switch args[idx] {
+		case "-C", "-c":
+			idx++
+		default:
+			if !strings.HasPrefix(args[idx], "-") {
+				return args[idx]
+			}
+		}
+	}
+	return ""
+}
+
+func (git GitCLIWrapper) runGitInvocation(invocation gitInvocation) ([]byte, error) {
+	git.logger.Debugf("running command: %s %s in %s", gitCmd, invocation.args, git.cmd.Dir)
+
+	args := invocation.args
+	env := invocation.env
+	if git.safeMode != nil {
+		args = append(append([]string{}, git.safeMode.configArgs...), args...)
+		env = append(append([]string{}, git.safeMode.env...), env...)
+	}
+
+	cmd := exec.Command(gitCmd, args...)
+	cmd.Dir = git.cmd.Dir
+	cmd.Stdin = invocation.stdin
+
+	tracePath := ""
+	if git.tracer != nil {
+		var err error
+		if tracePath, err = createTraceFile(); err != nil {
+			git.logger.Warnf("failed to create a trace2 file, running without tracing: %s", err)
+		} else {
+			defer os.Remove(tracePath)
+			env = append(env, "GIT_TRACE2_EVENT="+tracePath)
+		}
+	}
+	if len(env) > 0 {
+		cmd.Env = append(cmd.Environ(), env...)
+	}
+
+	var stdOut, stdErr bytes.Buffer
+	cmd.Stdout = &stdOut
+	cmd.Stderr = &stdErr
+
+	started := time.Now()
+	err := cmd.Run()
+	if stdErr.Len() > 0 {
+		git.logger.Debug(strings.TrimSpace(stdErr.String()))
+	}
+
+	var performance *PerformanceSummary
+	if tracePath != "" && cmd.ProcessState != nil {
+		performance = git.tracer.record(git.logger, tracePath, invocation.args, time.Since(started), cmd.ProcessState.ExitCode())
+	}
+
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			git.logger.Debugf("exited with code %d", cmd.ProcessState.ExitCode())
+			return stdOut.Bytes(), &CommandError{
+				Subcommand:  gitSubcommand(invocation.args),
+				ExitCode:    cmd.ProcessState.ExitCode(),
+				Stderr:      stdErr.String(),
+				Performance: performance,
+			}
+		}
+		git.logger.Error("running command failed")
+		return nil, err
+	}
+
+	return stdOut.Bytes(), nil
+}
+
+func (git GitCLIWrapper) runGit(args ...string) (string, error) {
+	stdOut, err := git.runGitInvocation(gitInvocation{args: args})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(stdOut)), nil
+}
+
+func (git GitCLIWrapper) runGitRaw(args ...string) ([]byte, error) {
+	return git.runGitInvocation(gitInvocation{args: args})
+}
+
+func (git GitCLIWrapper) runGitRawWithInput(stdin string, args ...string) ([]byte, error) {
+	return git.runGitInvocation(gitInvocation{args: args, stdin: strings.NewReader(stdin)})
+}
+
+// runCommand keeps the shape of cmdwrapper's RunCommand, so the original
+// methods share the same invocation path as everything else.
+func (git GitCLIWrapper) runCommand(args ...string) (*string, *int, error) {
+	stdOut, err := git.runGitInvocation(gitInvocation{args: args})
+	code := 0
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) {
+		code = commandErr.ExitCode
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	stdOutString := strings.TrimSpace(string(stdOut))
+	return &stdOutString, &code, nil
+}
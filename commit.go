@@ -0,0 +1,51 @@
+package gitcliwrapper
+
+import "errors"
+
+var ErrNothingToCommit = errors.New("there are no changes to commit")
+
+func (git GitCLIWrapper) CheckoutNewBranch(name, startPoint string) error {
+	git.logger.Debugf("creating and checking out branch %s from %s", name, startPoint)
+	args := []string{"checkout", "--quiet", "-b", name}
+	if startPoint != "" {
+		args = append(args, startPoint)
+	}
+	if _, err := git.runGit(args...); err != nil {
+		git.logger.Warnf("failed to create branch %s", name)
+		return err
+	}
+	return nil
+}
+
+// Commit stages the given paths, or every change when no paths are given,
+// and commits them. ErrNothingToCommit is returned when nothing is staged.
+func (git GitCLIWrapper) Commit(message string, paths ...string) (*string, error) {
+	git.logger.Debug("committing changes")
+	addArgs := []string{"add", "--all", "--"}
+	if len(paths) > 0 {
+		addArgs = append(addArgs, paths...)
+	}
+	if _, err := git.runGit(addArgs...); err != nil {
+		git.logger.Warn("failed to stage changes")
+		return nil, err
+	}
+
+	if _, err := git.runGit("diff", "--cached", "--quiet"); err == nil {
+		return nil, ErrNothingToCommit
+	}
+
+	commitArgs := []string{"commit", "--quiet", "-m", message}
+	if len(paths) > 0 {
+		commitArgs = append(append(commitArgs, "--"), paths...)
+	}
+	if _, err := git.runGit(commitArgs...); err != nil {
+		git.logger.Warn("failed to commit changes")
+		return nil, err
+	}
+
+	hash, err := git.runGit("rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return &hash, nil
+}
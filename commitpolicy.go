@@ -0,0 +1,229 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"net/mail"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxFutureSkew = 5 * time.Minute
+	defaultMaxOutOfOrder = 24 * time.Hour
+)
+
+const (
+	FindingMissingIdentity  = "missing_identity"
+	FindingMalformedEmail   = "malformed_email"
+	FindingDisallowedEmail  = "disallowed_email"
+	FindingIdentityMismatch = "identity_mismatch"
+	FindingFutureDate       = "future_date"
+	FindingOutOfOrderDate   = "out_of_order_date"
+)
+
+const commitMetadataFieldCount = 8
+
+type CommitMetadataPolicy struct {
+	// AllowedDomains are email domains accepted for authors and committers,
+	// subdomains are accepted too
+	AllowedDomains []string
+	// AllowedEmails are individual addresses accepted regardless of domain
+	AllowedEmails []string
+	// BotEmails are glob patterns for bot identities, which are exempt from
+	// the author and committer mismatch check
+	BotEmails []string
+	// MaxFutureSkew is how far ahead of now a date may be, defaults to 5m
+	MaxFutureSkew time.Duration
+	// MaxOutOfOrder is how much older than its parent a commit may be,
+	// defaults to 24h
+	MaxOutOfOrder time.Duration
+	// Now overrides the clock used for future date checks
+	Now func() time.Time
+}
+
+type CommitFinding struct {
+	Kind    string
+	Message string
+}
+
+type CommitMetadataResult struct {
+	Hash     string
+	Findings []CommitFinding
+}
+
+type commitIdentity struct {
+	name  string
+	email string
+	date  time.Time
+}
+
+type commitMetadata struct {
+	hash      string
+	parents   []string
+	author    commitIdentity
+	committer commitIdentity
+}
+
+// CheckCommitMetadata only returns commits which have at least one finding.
+func (git GitCLIWrapper) CheckCommitMetadata(commitRange string, policy CommitMetadataPolicy) ([]CommitMetadataResult, error) {
+	git.logger.Debugf("checking commit metadata for %s", commitRange)
+
+	stdOut, err := git.runGit("log", "--format=%H%x00%P%x00%an%x00%ae%x00%aI%x00%cn%x00%ce%x00%cI%x01", commitRange)
+	if err != nil {
+		git.logger.Warnf("failed to list commit metadata for %s", commitRange)
+		return nil, err
+	}
+
+	var commits []commitMetadata
+	for _, record := range strings.Split(stdOut, "\x01") {
+		record = strings.TrimSpace(record)
+		if record == "" {
+			continue
+		}
+		fields := strings.Split(record, "\x00")
+		if len(fields) != commitMetadataFieldCount {
+			hash, _, _ := strings.Cut(record, "\x00")
+			return nil, fmt.Errorf("git log returned an unexpected format for commit %s", hash)
+		}
+		commit := commitMetadata{
+			hash:      fields[0],
+			parents:   strings.Fields(fields[1]),
+			author:    commitIdentity{name: fields[2], email: fields[3]},
+			committer: commitIdentity{name: fields[5], email: fields[6]},
+		}
+		if commit.author.date, err = time.Parse(time.RFC3339, fields[4]); err != nil {
+			git.logger.Warnf("author date for %s came back in an unexpected format", commit.hash)
+			return nil, err
+		}
+		if commit.committer.date, err = time.Parse(time.RFC3339, fields[7]); err != nil {
+			git.logger.Warnf("committer date for %s came back in an unexpected format", commit.hash)
+			return nil, err
+		}
+		commits = append(commits, commit)
+	}
+
+	committerDates := map[string]time.Time{}
+	for _, commit := range commits {
+		committerDates[commit.hash] = commit.committer.date
+	}
+
+	now := time.Now()
+	if policy.Now != nil {
+		now = policy.Now()
+	}
+	maxFutureSkew := policy.MaxFutureSkew
+	if maxFutureSkew == 0 {
+		maxFutureSkew = defaultMaxFutureSkew
+	}
+	maxOutOfOrder := policy.MaxOutOfOrder
+	if maxOutOfOrder == 0 {
+		maxOutOfOrder = defaultMaxOutOfOrder
+	}
+
+	var results []CommitMetadataResult
+	for _, commit := range commits {
+		var findings []CommitFinding
+		findings = append(findings, policy.checkIdentity("author", commit.author)...)
+		findings = append(findings, policy.checkIdentity("committer", commit.committer)...)
+
+		if !policy.isBot(commit.author.email) && !policy.isBot(commit.committer.email) &&
+			!strings.EqualFold(commit.author.email, commit.committer.email) {
+			findings = append(findings, CommitFinding{
+				Kind:    FindingIdentityMismatch,
+				Message: fmt.Sprintf("author %s differs from committer %s", commit.author.email, commit.committer.email),
+			})
+		}
+
+		if commit.author.date.After(now.Add(maxFutureSkew)) {
+			findings = append(findings, CommitFinding{
+				Kind:    FindingFutureDate,
+				Message: fmt.Sprintf("author date %s is in the future", commit.author.date.Format(time.RFC3339)),
+			})
+		}
+		if commit.committer.date.After(now.Add(maxFutureSkew)) {
+			findings = append(findings, CommitFinding{
+				Kind:    FindingFutureDate,
+				Message: fmt.Sprintf("committer date %s is in the future", commit.committer.date.Format(time.RFC3339)),
+			})
+		}
+
+		for _, parent := range commit.parents {
+			parentDate, ok := committerDates[parent]
+			if !ok {
+				continue
+			}
+			if commit.committer.date.Before(parentDate.Add(-maxOutOfOrder)) {
+				findings = append(findings, CommitFinding{
+					Kind: FindingOutOfOrderDate,
+					Message: fmt.Sprintf("committer date %s is well before parent %s date %s",
+						commit.committer.date.Format(time.RFC3339), shortHash(parent), parentDate.Format(time.RFC3339)),
+				})
+			}
+		}
+
+		if len(findings) > 0 {
+			results = append(results, CommitMetadataResult{Hash: commit.hash, Findings: findings})
+		}
+	}
+
+	return results, nil
+}
+
+func (policy CommitMetadataPolicy) checkIdentity(role string, identity commitIdentity) []CommitFinding {
+	if strings.TrimSpace(identity.name) == "" || strings.TrimSpace(identity.email) == "" {
+		return []CommitFinding{{
+			Kind:    FindingMissingIdentity,
+			Message: fmt.Sprintf("%s name or email is empty", role),
+		}}
+	}
+
+	address, err := mail.ParseAddress("<" + identity.email + ">")
+	if err != nil || !strings.Contains(address.Address[strings.LastIndex(address.Address, "@")+1:], ".") {
+		return []CommitFinding{{
+			Kind:    FindingMalformedEmail,
+			Message: fmt.Sprintf("%s email %s is malformed", role, identity.email),
+		}}
+	}
+
+	if !policy.isAllowed(identity.email) {
+		return []CommitFinding{{
+			Kind:    FindingDisallowedEmail,
+			Message: fmt.Sprintf("%s email %s is not allowed", role, identity.email),
+		}}
+	}
+
+	return nil
+}
+
+func (policy CommitMetadataPolicy) isAllowed(email string) bool {
+	if len(policy.AllowedDomains) == 0 && len(policy.AllowedEmails) == 0 {
+		return true
+	}
+
+	email = strings.ToLower(email)
+	for _, allowed := range policy.AllowedEmails {
+		if strings.ToLower(allowed) == email {
+			return true
+		}
+	}
+
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range policy.AllowedDomains {
+		allowed = strings.ToLower(strings.TrimPrefix(allowed, "@"))
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (policy CommitMetadataPolicy) isBot(email string) bool {
+	for _, pattern := range policy.BotEmails {
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(email)); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type CompareOptions struct {
+	// Refs are glob patterns limiting which refs are compared, all refs
+	// except HEAD are compared when empty
+	Refs []string
+	// VerifyObjects checks that every object reachable from one side's refs
+	// exists on the other, this needs both sides to be local repositories
+	VerifyObjects bool
+}
+
+type RefDifference struct {
+	Ref string
+	A   string
+	B   string
+}
+
+type RepositoryComparison struct {
+	MissingFromA []string
+	MissingFromB []string
+	Different    []RefDifference
+	// ObjectsVerified is set when the object check ran
+	ObjectsVerified bool
+	// IncompleteInA are refs of B whose reachable objects are not all in A
+	IncompleteInA []string
+	// IncompleteInB are refs of A whose reachable objects are not all in B
+	IncompleteInB []string
+}
+
+func (comparison RepositoryComparison) Consistent() bool {
+	return len(comparison.MissingFromA) == 0 && len(comparison.MissingFromB) == 0 &&
+		len(comparison.Different) == 0 && len(comparison.IncompleteInA) == 0 && len(comparison.IncompleteInB) == 0
+}
+
+func (git GitCLIWrapper) listRepositoryRefs(repository string, patterns []string) (map[string]string, error) {
+	stdOut, err := git.runGit("ls-remote", repository)
+	if err != nil {
+		git.logger.Warnf("failed to list refs of %s", stripCredentials(repository))
+		return nil, err
+	}
+
+	refs := map[string]string{}
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] == "HEAD" || strings.HasSuffix(fields[1], "^{}") {
+			continue
+		}
+		if len(patterns) > 0 && !matchesAnyRefPattern(fields[1], patterns) {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+	return refs, nil
+}
+
+func matchesAnyRefPattern(ref string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, ref); matched {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(ref, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareRepositories compares the refs of two repositories, which can be
+// remote names, URLs or paths to local repositories.
+func (git GitCLIWrapper) CompareRepositories(a, b string, opts CompareOptions) (*RepositoryComparison, error) {
+	git.logger.Debugf("comparing repositories %s and %s", stripCredentials(a), stripCredentials(b))
+
+	refsA, err := git.listRepositoryRefs(a, opts.Refs)
+	if err != nil {
+		return nil, err
+	}
+	refsB, err := git.listRepositoryRefs(b, opts.Refs)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &RepositoryComparison{}
+	for ref, hashA := range refsA {
+		hashB, ok := refsB[ref]
+		switch {
+		case !ok:
+			comparison.MissingFromB = append(comparison.MissingFromB, ref)
+		case hashA != hashB:
+			comparison.Different = append(comparison.Different, RefDifference{Ref: ref, A: hashA, B: hashB})
+		}
+	}
+	for ref := range refsB {
+		if _, ok := refsA[ref]; !ok {
+			comparison.MissingFromA = append(comparison.MissingFromA, ref)
+		}
+	}
+	sort.Strings(comparison.MissingFromA)
+	sort.Strings(comparison.MissingFromB)
+	sort.Slice(comparison.Different, func(i, j int) bool {
+		return comparison.Different[i].Ref < comparison.Different[j].Ref
+	})
+
+	if opts.VerifyObjects {
+		localA, err := git.localRepositoryPath(a)
+		if err != nil {
+			return nil, err
+		}
+		localB, err := git.localRepositoryPath(b)
+		if err != nil {
+			return nil, err
+		}
+		if comparison.IncompleteInB, err = git.incompleteRefs(localB, refsA); err != nil {
+			return nil, err
+		}
+		if comparison.IncompleteInA, err = git.incompleteRefs(localA, refsB); err != nil {
+			return nil, err
+		}
+		comparison.ObjectsVerified = true
+	}
+
+	return comparison, nil
+}
+
+func (git GitCLIWrapper) localRepositoryPath(repository string) (string, error) {
+	localPath := strings.TrimPrefix(repository, "file://")
+	if !filepath.IsAbs(localPath) {
+		localPath = filepath.Join(git.cmd.Dir, localPath)
+	}
+	if info, err := os.Stat(localPath); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("object verification needs local repositories, %s is not one", stripCredentials(repository))
+	}
+	return localPath, nil
+}
+
+// incompleteRefs returns the refs whose tips, or anything reachable from
+// them, are missing from the given repository.
+func (git GitCLIWrapper) incompleteRefs(repository string, refs map[string]string) ([]string, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	tips := map[string][]string{}
+	for ref, hash := range refs {
+		tips[hash] = append(tips[hash], ref)
+	}
+	hashes := make([]string, 0, len(tips))
+	for hash := range tips {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	stdOut, err := git.runGitRawWithInput(strings.Join(hashes, "\n")+"\n", "-C", repository, "cat-file", "--batch-check")
+	if err != nil {
+		git.logger.Warnf("failed to check objects in %s", repository)
+		return nil, err
+	}
+
+	var incomplete []string
+	var present []string
+	for _, line := range strings.Split(string(stdOut), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "missing" {
+			incomplete = append(incomplete, tips[fields[0]]...)
+		} else if len(fields) == 3 {
+			present = append(present, fields[0])
+		}
+	}
+
+	// walking everything at once is cheap when complete, each tip is only
+	// walked on its own to find the culprits when something is missing
+	walk := func(hashes []string) error {
+		_, err := git.runGitRawWithInput(strings.Join(hashes, "\n")+"\n", "-C", repository, "rev-list", "--objects", "--quiet", "--stdin")
+		return err
+	}
+	if len(present) > 0 && walk(present) != nil {
+		for _, hash := range present {
+			if walk([]string{hash}) != nil {
+				incomplete = append(incomplete, tips[hash]...)
+			}
+		}
+	}
+
+	sort.Strings(incomplete)
+	return incomplete, nil
+}
@@ -0,0 +1,236 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ForwardMergeMerged       = "merged"
+	ForwardMergeFastForward  = "fast_forward"
+	ForwardMergeUpToDate     = "up_to_date"
+	ForwardMergeConflict     = "conflict"
+	ForwardMergeNotAttempted = "not_attempted"
+)
+
+var ErrForwardMergeConflict = errors.New("forward merge stopped at a conflict")
+
+// merge-tree --write-tree was added in 2.38
+const (
+	mergeTreeMinMajor = 2
+	mergeTreeMinMinor = 38
+)
+
+type ForwardMergeOptions struct {
+	// NoFetch uses the remote tracking refs as they are
+	NoFetch bool
+	// NoPush only reports the merges when working against a remote, the
+	// merge commits are created but no refs are updated
+	NoPush bool
+	// MessageFormat is passed the source and target branch, defaults to
+	// "Merge branch '%s' into %s"
+	MessageFormat string
+}
+
+type ForwardMergeLink struct {
+	From   string
+	To     string
+	Status string
+	// Previous is the target before the merge and Merged the target after
+	Previous string
+	Merged   string
+	// ConflictedFiles and Messages describe a conflict as reported by git
+	ConflictedFiles []string
+	Messages        []string
+}
+
+type ForwardMergeResult struct {
+	Links  []ForwardMergeLink
+	Pushed bool
+}
+
+// forwardMergeRef is where the branches of the chain are read from and
+// written to, the remote tracking refs when working against a remote.
+func (git GitCLIWrapper) forwardMergeRef(branch string) string {
+	if git.remote != "" {
+		return fmt.Sprintf("refs/remotes/%s/%s", git.remote, branch)
+	}
+	return "refs/heads/" + branch
+}
+
+// ForwardMerge merges each branch of the chain into the next one, such as
+// release/1.1 into release/1.2 and release/1.2 into main, without touching
+// the working tree. Links already up to date are skipped. At the first
+// conflict the chain stops, the merges before it are still pushed, and
+// ErrForwardMergeConflict is returned together with the report.
+func (git GitCLIWrapper) ForwardMerge(chain []string, opts ForwardMergeOptions) (*ForwardMergeResult, error) {
+	git.logger.Debugf("forward merging %s", strings.Join(chain, " -> "))
+	if len(chain) < 2 {
+		return nil, fmt.Errorf("a forward merge chain needs at least two branches")
+	}
+
+	major, minor, err := git.gitVersion()
+	if err != nil {
+		git.logger.Warn("failed to determine the git version")
+		return nil, err
+	}
+	if major < mergeTreeMinMajor || (major == mergeTreeMinMajor && minor < mergeTreeMinMinor) {
+		return nil, fmt.Errorf("forward merges need git %d.%d or later for merge-tree --write-tree, found %d.%d", mergeTreeMinMajor, mergeTreeMinMinor, major, minor)
+	}
+
+	if git.remote != "" && !opts.NoFetch {
+		refSpecs := []string{"fetch", "--quiet", git.remote}
+		for _, branch := range chain {
+			refSpecs = append(refSpecs, fmt.Sprintf("+%s:%s", git.namespacedRef("refs/heads/"+branch), git.forwardMergeRef(branch)))
+		}
+		if _, err := git.runGit(refSpecs...); err != nil {
+			git.logger.Warnf("failed to fetch the chain from remote %s", git.remote)
+			return nil, err
+		}
+	}
+	if bare, _ := git.runGit("rev-parse", "--is-bare-repository"); git.remote == "" && bare != "true" {
+		if current, err := git.runGit("symbolic-ref", "--quiet", "--short", "HEAD"); err == nil {
+			for _, branch := range chain[1:] {
+				if branch == current {
+					return nil, fmt.Errorf("branch %s is checked out and can not be merged into without a worktree", branch)
+				}
+			}
+		}
+	}
+
+	hashes := map[string]string{}
+	for _, branch := range chain {
+		hash, err := git.runGit("rev-parse", "--verify", git.forwardMergeRef(branch)+"^{commit}")
+		if err != nil {
+			git.logger.Warnf("failed to resolve branch %s", branch)
+			return nil, err
+		}
+		hashes[branch] = hash
+	}
+
+	messageFormat := opts.MessageFormat
+	if messageFormat == "" {
+		messageFormat = "Merge branch '%s' into %s"
+	}
+
+	result := &ForwardMergeResult{}
+	var mergeErr error
+	for idx := 0; idx+1 < len(chain); idx++ {
+		from, to := chain[idx], chain[idx+1]
+		link := ForwardMergeLink{From: from, To: to, Previous: hashes[to], Status: ForwardMergeNotAttempted}
+		if mergeErr != nil {
+			result.Links = append(result.Links, link)
+			continue
+		}
+
+		source, target := hashes[from], hashes[to]
+		if _, err := git.runGit("merge-base", "--is-ancestor", source, target); err == nil {
+			link.Status = ForwardMergeUpToDate
+			link.Merged = target
+			result.Links = append(result.Links, link)
+			continue
+		}
+		if _, err := git.runGit("merge-base", "--is-ancestor", target, source); err == nil {
+			link.Status = ForwardMergeFastForward
+			link.Merged = source
+			hashes[to] = source
+			result.Links = append(result.Links, link)
+			continue
+		}
+
+		stdOut, err := git.runGitRaw("merge-tree", "--write-tree", "--name-only", target, source)
+		var commandErr *CommandError
+		if errors.As(err, &commandErr) && commandErr.ExitCode == 1 {
+			link.Status = ForwardMergeConflict
+			link.ConflictedFiles, link.Messages = parseMergeTreeConflicts(string(stdOut))
+			git.logger.Warnf("merging %s into %s conflicts in %s", from, to, link.ConflictedFiles)
+			mergeErr = ErrForwardMergeConflict
+			result.Links = append(result.Links, link)
+			continue
+		}
+		if err != nil {
+			git.logger.Warnf("failed to merge %s into %s", from, to)
+			return nil, err
+		}
+
+		tree, _, _ := strings.Cut(string(stdOut), "\n")
+		merged, err := git.runGit("commit-tree", tree, "-p", target, "-p", source, "-m", fmt.Sprintf(messageFormat, from, to))
+		if err != nil {
+			git.logger.Warnf("failed to commit the merge of %s into %s", from, to)
+			return nil, err
+		}
+		link.Status = ForwardMergeMerged
+		link.Merged = merged
+		hashes[to] = merged
+		result.Links = append(result.Links, link)
+	}
+
+	if err := git.storeForwardMerges(result, opts); err != nil {
+		return result, err
+	}
+	return result, mergeErr
+}
+
+// parseMergeTreeConflicts reads the output of merge-tree --name-only, the
+// tree is followed by the conflicted files, a blank line and the messages.
+func parseMergeTreeConflicts(output string) ([]string, []string) {
+	var files, messages []string
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	inMessages := false
+	for _, line := range lines[1:] {
+		switch {
+		case inMessages:
+			messages = append(messages, line)
+		case line == "":
+			inMessages = true
+		default:
+			files = append(files, line)
+		}
+	}
+	return files, messages
+}
+
+func (git GitCLIWrapper) storeForwardMerges(result *ForwardMergeResult, opts ForwardMergeOptions) error {
+	var refSpecs []string
+	leases := map[string]string{}
+	for _, link := range result.Links {
+		if link.Status != ForwardMergeMerged && link.Status != ForwardMergeFastForward {
+			continue
+		}
+		refSpecs = append(refSpecs, link.Merged+":refs/heads/"+link.To)
+		leases["refs/heads/"+link.To] = link.Previous
+	}
+	if len(refSpecs) == 0 {
+		return nil
+	}
+
+	if git.remote != "" {
+		if opts.NoPush {
+			return nil
+		}
+		if err := git.push(git.remote, refSpecs, PushOptions{Atomic: true, Leases: leases}); err != nil {
+			git.logger.Warnf("failed to push the forward merges to remote %s", git.remote)
+			return err
+		}
+		result.Pushed = true
+	}
+
+	for _, link := range result.Links {
+		if link.Status != ForwardMergeMerged && link.Status != ForwardMergeFastForward {
+			continue
+		}
+		ref := git.forwardMergeRef(link.To)
+		args := []string{"update-ref", "-m", "forward merge from " + link.From, ref, link.Merged}
+		if git.remote == "" {
+			// the push already moves remote tracking refs, local branches are
+			// only moved if nothing changed them in the meantime
+			args = append(args, link.Previous)
+		}
+		if _, err := git.runGit(args...); err != nil {
+			git.logger.Warnf("failed to update %s", ref)
+			return err
+		}
+	}
+	return nil
+}
@@ -42,9 +42,13 @@ const (
 )
 
 type GitCLIWrapper struct {
-	remote string
-	logger logger
-	cmd    cmdwrapper.CMDWrapper
+	remote     string
+	logger     logger
+	cmd        cmdwrapper.CMDWrapper
+	outboxPath string
+	tracer     *trace2Recorder
+	safeMode   *safeModeConfig
+	namespace  string
 }
 
 func nonZeroCode(text string) error {
@@ -57,7 +61,7 @@ func (git *GitCLIWrapper) GetRemote() (*string, error) {
 	}
 
 	git.logger.Debug("looking up git remote")
-	remote, code, err := git.cmd.RunCommand(gitCmd, "remote")
+	remote, code, err := git.runCommand("remote")
 	if err != nil {
 		git.logger.Error("failed to lookup git remote")
 		return nil, err
@@ -86,7 +90,7 @@ func (git *GitCLIWrapper) GetRemote() (*string, error) {
 
 func (git GitCLIWrapper) GetLastCommitOnRef(ref string) (*string, error) {
 	git.logger.Debugf("get most recent commit for reference %s on remote %s", ref, git.remote)
-	stdOut, code, err := git.cmd.RunCommand(gitCmd, "rev-list", "-n", "1", ref)
+	stdOut, code, err := git.runCommand("rev-list", "-n", "1", ref)
 	if code != nil && *code != 0 {
 		return nil, nonZeroCode("rev-list")
 	}
@@ -103,7 +107,7 @@ func (git GitCLIWrapper) GetLastCommitOnRef(ref string) (*string, error) {
 
 func (git GitCLIWrapper) Fetch() error {
 	git.logger.Debugf("running git fetch against remote %s", git.remote)
-	_, code, err := git.cmd.RunCommand(gitCmd, "fetch", git.remote)
+	_, code, err := git.runCommand("fetch", git.remote)
 	if code != nil && *code != 0 {
 		return nonZeroCode("fetch")
 	}
@@ -112,7 +116,14 @@ func (git GitCLIWrapper) Fetch() error {
 
 func (git GitCLIWrapper) ListRemoteRefs(refType string) ([]string, error) {
 	git.logger.Infof("attempting to get a list of remote %s in git from %s", refType, git.remote)
-	remoteRefsResponse, code, err := git.cmd.RunCommand(gitCmd, "ls-remote", "--"+refType, git.remote)
+	refPrefix := "refs/" + refType + "/"
+	lsRemoteArgs := []string{"ls-remote", "--" + refType, git.remote}
+	if git.namespace != "" {
+		// --heads and --tags only match refs outside of namespaces
+		refPrefix = git.namespacedRef(refPrefix)
+		lsRemoteArgs = []string{"ls-remote", git.remote, refPrefix + "*"}
+	}
+	remoteRefsResponse, code, err := git.runCommand(lsRemoteArgs...)
 	if err != nil {
 		git.logger.Warn("failed to lookup from remote")
 		return nil, err
@@ -126,7 +137,7 @@ func (git GitCLIWrapper) ListRemoteRefs(refType string) ([]string, error) {
 
 	var remoteRefs []string
 	for _, remoteRef := range strings.Split(*remoteRefsResponse, "\n") {
-		splitRemoteRef := strings.Split(remoteRef, "refs/"+refType+"/")
+		splitRemoteRef := strings.Split(remoteRef, "\t"+refPrefix)
 		if len(splitRemoteRef) != 2 {
 			git.logger.Warnf("attempted to parse a reference of unexpected format: %s", remoteRef)
 			continue
@@ -139,7 +150,7 @@ func (git GitCLIWrapper) ListRemoteRefs(refType string) ([]string, error) {
 
 func (git GitCLIWrapper) ListCommits(commitRange ...string) ([]string, error) {
 	git.logger.Debug("looking up git commits")
-	stdOut, code, err := git.cmd.RunCommand(gitCmd, append([]string{"log", `--pretty=format:"%H"`}, commitRange...)...)
+	stdOut, code, err := git.runCommand(append([]string{"log", `--pretty=format:"%H"`}, commitRange...)...)
 	if err != nil {
 		git.logger.Warn("failed to run git log")
 		return nil, err
@@ -162,7 +173,7 @@ func (git GitCLIWrapper) ListCommits(commitRange ...string) ([]string, error) {
 
 func (git GitCLIWrapper) GetCurrentBranch() (*string, error) {
 	git.logger.Debug("getting the current branch")
-	stdOut, code, err := git.cmd.RunCommand(gitCmd, "rev-parse", "--abbrev-ref", "HEAD")
+	stdOut, code, err := git.runCommand("rev-parse", "--abbrev-ref", "HEAD")
 	if err != nil {
 		git.logger.Warn("failed to get the current git branch")
 		return nil, err
@@ -176,7 +187,7 @@ func (git GitCLIWrapper) GetCurrentBranch() (*string, error) {
 
 func (git GitCLIWrapper) GetCommitMessageBody(hash string) (*string, error) {
 	git.logger.Debugf("getting the commit message for %s", hash)
-	stdOut, code, err := git.cmd.RunCommand(gitCmd, "log", "--format=%B", "-n", "1", hash)
+	stdOut, code, err := git.runCommand("log", "--format=%B", "-n", "1", hash)
 	if err != nil {
 		git.logger.Warnf("failed to get the commit message for %s", hash)
 		return nil, err
@@ -190,7 +201,7 @@ func (git GitCLIWrapper) GetCommitMessageBody(hash string) (*string, error) {
 
 func (git GitCLIWrapper) GetReferenceDateTime(ref string) (*time.Time, error) {
 	git.logger.Debugf("going to try to get the date time for the reference %s", ref)
-	stdOut, code, err := git.cmd.RunCommand(gitCmd, "log", "--format=%cd", "-n", "1", ref)
+	stdOut, code, err := git.runCommand("log", "--format=%cd", "-n", "1", ref)
 	if err != nil {
 		git.logger.Warnf("failed to get the commit date time for %s", ref)
 		return nil, err
@@ -213,14 +224,10 @@ func (git GitCLIWrapper) GetReferenceDateTime(ref string) (*time.Time, error) {
 
 func (git GitCLIWrapper) ForcePushSourceToTargetRef(sourceRef, targetRef string) error {
 	git.logger.Debugf("going to try to push %s to %s on remote %s", sourceRef, targetRef, git.remote)
-	_, code, err := git.cmd.RunCommand(gitCmd, "push", "-f", git.remote, fmt.Sprintf("%s:%s", sourceRef, targetRef))
-	if err != nil {
+	if err := git.Push([]string{fmt.Sprintf("%s:%s", sourceRef, targetRef)}, PushOptions{Force: true}); err != nil {
 		git.logger.Warnf("failed to force push to git ref %s on remote %s", targetRef, git.remote)
 		return err
 	}
-	if code != nil && *code != 0 {
-		return nonZeroCode("push")
-	}
 
 	return nil
 }
@@ -0,0 +1,57 @@
+package gitcliwrapper
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Debug(args ...any)                   { l.t.Log(args...) }
+func (l testLogger) Debugf(template string, args ...any) { l.t.Logf(template, args...) }
+func (l testLogger) Infof(template string, args ...any)  { l.t.Logf(template, args...) }
+func (l testLogger) Warn(args ...any)                    { l.t.Log(args...) }
+func (l testLogger) Warnf(template string, args ...any)  { l.t.Logf(template, args...) }
+func (l testLogger) Error(args ...any)                   { l.t.Log(args...) }
+func (l testLogger) Errorf(template string, args ...any) { l.t.Logf(template, args...) }
+
+// runTestGit runs git in dir with a fixed identity and fails the test when
+// it exits non zero.
+func runTestGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(gitCmd, append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(cmd.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL="+os.DevNull,
+		"GIT_AUTHOR_NAME=Test",
+		"GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test",
+		"GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newTestRepository initialises a repository with one commit on main and
+// returns a wrapper for it.
+func newTestRepository(t *testing.T, remote ...string) (*GitCLIWrapper, string) {
+	t.Helper()
+	dir := t.TempDir()
+	runTestGit(t, dir, "init", "--quiet", "--initial-branch=main")
+	runTestGit(t, dir, "config", "user.name", "Test")
+	runTestGit(t, dir, "config", "user.email", "test@example.com")
+	runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "initial")
+
+	git, err := NewGitCLIWrapper(dir, testLogger{t}, remote...)
+	if err != nil {
+		t.Fatalf("failed to create wrapper: %s", err)
+	}
+	return git, dir
+}
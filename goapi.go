@@ -0,0 +1,354 @@
+package gitcliwrapper
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	APIAdded   = "added"
+	APIRemoved = "removed"
+	APIChanged = "changed"
+)
+
+const (
+	SemverMajor = "major"
+	SemverMinor = "minor"
+	SemverPatch = "patch"
+)
+
+type APIChange struct {
+	Package    string
+	Identifier string
+	Kind       string
+	Before     string
+	After      string
+}
+
+type GoAPIDiffResult struct {
+	Changes       []APIChange
+	SuggestedBump string
+}
+
+type treeBlob struct {
+	hash string
+	path string
+}
+
+// goPackageAPI maps a package directory to its exported identifiers and
+// their declarations.
+type goPackageAPI map[string]map[string]string
+
+func (git GitCLIWrapper) GoAPIDiff(from, to string, pkgPatterns []string) (*GoAPIDiffResult, error) {
+	git.logger.Debugf("comparing the exported go api between %s and %s", from, to)
+
+	before, err := git.goAPIAt(from, pkgPatterns)
+	if err != nil {
+		return nil, err
+	}
+	after, err := git.goAPIAt(to, pkgPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GoAPIDiffResult{SuggestedBump: SemverPatch}
+	packages := map[string]bool{}
+	for pkg := range before {
+		packages[pkg] = true
+	}
+	for pkg := range after {
+		packages[pkg] = true
+	}
+
+	for pkg := range packages {
+		for ident, beforeDecl := range before[pkg] {
+			afterDecl, ok := after[pkg][ident]
+			switch {
+			case !ok:
+				result.Changes = append(result.Changes, APIChange{Package: pkg, Identifier: ident, Kind: APIRemoved, Before: beforeDecl})
+			case afterDecl != beforeDecl:
+				result.Changes = append(result.Changes, APIChange{Package: pkg, Identifier: ident, Kind: APIChanged, Before: beforeDecl, After: afterDecl})
+			}
+		}
+		for ident, afterDecl := range after[pkg] {
+			if _, ok := before[pkg][ident]; !ok {
+				result.Changes = append(result.Changes, APIChange{Package: pkg, Identifier: ident, Kind: APIAdded, After: afterDecl})
+			}
+		}
+	}
+
+	sort.Slice(result.Changes, func(i, j int) bool {
+		if result.Changes[i].Package != result.Changes[j].Package {
+			return result.Changes[i].Package < result.Changes[j].Package
+		}
+		return result.Changes[i].Identifier < result.Changes[j].Identifier
+	})
+
+	for _, change := range result.Changes {
+		if change.Kind != APIAdded {
+			result.SuggestedBump = SemverMajor
+			break
+		}
+		result.SuggestedBump = SemverMinor
+	}
+
+	return result, nil
+}
+
+func matchesGoPackagePattern(dir string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, "./"), "/")
+		if pattern == "..." {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/...") {
+			base := strings.TrimSuffix(pattern, "/...")
+			if dir == base || strings.HasPrefix(dir, base+"/") {
+				return true
+			}
+			continue
+		}
+		if pattern == "" || pattern == "." {
+			pattern = "."
+		}
+		if matched, _ := path.Match(pattern, dir); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func isGoAPISource(filePath string) bool {
+	if !strings.HasSuffix(filePath, ".go") || strings.HasSuffix(filePath, "_test.go") {
+		return false
+	}
+	for _, part := range strings.Split(path.Dir(filePath), "/") {
+		if part == "vendor" || part == "testdata" || strings.HasPrefix(part, "_") || (strings.HasPrefix(part, ".") && part != ".") {
+			return false
+		}
+	}
+	return true
+}
+
+func (git GitCLIWrapper) goAPIAt(rev string, pkgPatterns []string) (goPackageAPI, error) {
+	stdOut, err := git.runGit("ls-tree", "-r", "--full-tree", rev)
+	if err != nil {
+		git.logger.Warnf("failed to list the tree at %s", rev)
+		return nil, err
+	}
+
+	var blobs []treeBlob
+	for _, line := range strings.Split(stdOut, "\n") {
+		meta, filePath, found := strings.Cut(line, "\t")
+		fields := strings.Fields(meta)
+		if !found || len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(filePath); err == nil {
+			filePath = unquoted
+		}
+		if !isGoAPISource(filePath) || !matchesGoPackagePattern(path.Dir(filePath), pkgPatterns) {
+			continue
+		}
+		blobs = append(blobs, treeBlob{hash: fields[2], path: filePath})
+	}
+
+	contents, err := git.readBlobs(blobs)
+	if err != nil {
+		return nil, err
+	}
+
+	// the same identifier can be declared once per build constraint, so every
+	// distinct declaration is collected before they are joined
+	variants := map[string]map[string]map[string]bool{}
+	fset := token.NewFileSet()
+	for _, blob := range blobs {
+		file, err := parser.ParseFile(fset, blob.path, contents[blob.hash], parser.SkipObjectResolution)
+		if err != nil {
+			git.logger.Warnf("failed to parse %s at %s, skipping it", blob.path, rev)
+			continue
+		}
+		if file.Name.Name == "main" {
+			continue
+		}
+
+		pkg := path.Dir(blob.path)
+		if variants[pkg] == nil {
+			variants[pkg] = map[string]map[string]bool{}
+		}
+		for ident, decl := range exportedDeclarations(fset, file) {
+			if variants[pkg][ident] == nil {
+				variants[pkg][ident] = map[string]bool{}
+			}
+			variants[pkg][ident][decl] = true
+		}
+	}
+
+	api := goPackageAPI{}
+	for pkg, idents := range variants {
+		api[pkg] = map[string]string{}
+		for ident, decls := range idents {
+			var joined []string
+			for decl := range decls {
+				joined = append(joined, decl)
+			}
+			sort.Strings(joined)
+			api[pkg][ident] = strings.Join(joined, "; ")
+		}
+	}
+
+	return api, nil
+}
+
+// readBlobs reads the content of the given blobs in one cat-file call.
+func (git GitCLIWrapper) readBlobs(blobs []treeBlob) (map[string][]byte, error) {
+	contents := map[string][]byte{}
+	if len(blobs) == 0 {
+		return contents, nil
+	}
+
+	input := strings.Builder{}
+	for _, blob := range blobs {
+		input.WriteString(blob.hash + "\n")
+	}
+	stdOut, err := git.runGitRawWithInput(input.String(), "cat-file", "--batch")
+	if err != nil {
+		git.logger.Warn("failed to read blobs from the object database")
+		return nil, err
+	}
+
+	for len(stdOut) > 0 {
+		headerEnd := bytes.IndexByte(stdOut, '\n')
+		if headerEnd < 0 {
+			break
+		}
+		header := strings.Fields(string(stdOut[:headerEnd]))
+		stdOut = stdOut[headerEnd+1:]
+		if len(header) != 3 {
+			return nil, fmt.Errorf("unexpected cat-file output: %s", strings.Join(header, " "))
+		}
+		size, err := strconv.Atoi(header[2])
+		if err != nil || size+1 > len(stdOut) {
+			return nil, fmt.Errorf("unexpected cat-file size for %s", header[0])
+		}
+		contents[header[0]] = stdOut[:size]
+		stdOut = stdOut[size+1:]
+	}
+
+	return contents, nil
+}
+
+func exportedDeclarations(fset *token.FileSet, file *ast.File) map[string]string {
+	decls := map[string]string{}
+	render := func(node any) string {
+		buf := bytes.Buffer{}
+		printer.Fprint(&buf, fset, node)
+		return strings.Join(strings.Fields(buf.String()), " ")
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if !decl.Name.IsExported() {
+				continue
+			}
+			name := decl.Name.Name
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				receiver := receiverTypeName(decl.Recv.List[0].Type)
+				if !ast.IsExported(receiver) {
+					continue
+				}
+				name = receiver + "." + name
+			}
+			decls[name] = render(decl.Type)
+
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if !spec.Name.IsExported() {
+						continue
+					}
+					addTypeDeclarations(decls, spec, render)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						if !name.IsExported() {
+							continue
+						}
+						signature := decl.Tok.String()
+						if spec.Type != nil {
+							signature += " " + render(spec.Type)
+						}
+						decls[name.Name] = signature
+					}
+				}
+			}
+		}
+	}
+
+	return decls
+}
+
+func addTypeDeclarations(decls map[string]string, spec *ast.TypeSpec, render func(any) string) {
+	name := spec.Name.Name
+	typeParams := ""
+	if spec.TypeParams != nil {
+		typeParams = render(spec.TypeParams)
+	}
+	alias := ""
+	if spec.Assign.IsValid() {
+		alias = "= "
+	}
+
+	structType, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		// interfaces and other types are compared as a whole, as any change
+		// to an interface method set breaks implementers
+		decls[name] = strings.TrimSpace(fmt.Sprintf("type%s %s%s", typeParams, alias, render(spec.Type)))
+		return
+	}
+
+	// struct fields are tracked individually so new fields are additions
+	decls[name] = strings.TrimSpace(fmt.Sprintf("type%s %sstruct", typeParams, alias))
+	for _, field := range structType.Fields.List {
+		if len(field.Names) == 0 {
+			embedded := receiverTypeName(field.Type)
+			if ast.IsExported(embedded) {
+				decls[name+"."+embedded] = "embedded " + render(field.Type)
+			}
+			continue
+		}
+		for _, fieldName := range field.Names {
+			if fieldName.IsExported() {
+				decls[name+"."+fieldName.Name] = render(field.Type)
+			}
+		}
+	}
+}
+
+func receiverTypeName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(expr.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(expr.X)
+	case *ast.SelectorExpr:
+		return expr.Sel.Name
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
@@ -0,0 +1,368 @@
+package gitcliwrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const historyIndexVersion = 1
+
+const (
+	IndexFieldSubject = "subject"
+	IndexFieldBody    = "body"
+	IndexFieldTrailer = "trailer"
+	IndexFieldAuthor  = "author"
+	IndexFieldPath    = "path"
+)
+
+// messageFields are searched by query terms without a field filter
+var messageFields = []string{IndexFieldSubject, IndexFieldBody, IndexFieldTrailer}
+
+type IndexedCommit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// HistoryIndex is an inverted index over commit metadata, kept as a single
+// file on disk.
+type HistoryIndex struct {
+	path string
+	data historyIndexData
+}
+
+type historyIndexData struct {
+	Version int `json:"version"`
+	// Tips are the last indexed hash of each ref, the next build only walks
+	// commits which are not reachable from them
+	Tips    map[string]string `json:"tips"`
+	Commits []IndexedCommit   `json:"commits"`
+	// Postings maps field:term to positions in Commits, in ascending order
+	Postings map[string][]int `json:"postings"`
+}
+
+func tokenize(text string) []string {
+	seen := map[string]bool{}
+	var tokens []string
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(token) < 2 || seen[token] {
+			continue
+		}
+		seen[token] = true
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// pathTerms indexes a path and every directory above it, so a path filter
+// matches everything below a directory.
+func pathTerms(filePath string) []string {
+	var terms []string
+	parts := strings.Split(strings.ToLower(filePath), "/")
+	for idx := range parts {
+		terms = append(terms, strings.Join(parts[:idx+1], "/"))
+	}
+	return terms
+}
+
+func OpenHistoryIndex(path string) (*HistoryIndex, error) {
+	index := &HistoryIndex{
+		path: path,
+		data: historyIndexData{Version: historyIndexVersion, Tips: map[string]string{}, Postings: map[string][]int{}},
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &index.data); err != nil {
+		return nil, fmt.Errorf("failed to parse history index %s: %w", path, err)
+	}
+	if index.data.Version != historyIndexVersion {
+		return nil, fmt.Errorf("history index %s has unsupported version %d", path, index.data.Version)
+	}
+	return index, nil
+}
+
+func (index *HistoryIndex) save() error {
+	raw, err := json.Marshal(index.data)
+	if err != nil {
+		return err
+	}
+	tmpPath := index.path + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, index.path)
+}
+
+func (index *HistoryIndex) add(commit IndexedCommit, terms map[string][]string) {
+	id := len(index.data.Commits)
+	index.data.Commits = append(index.data.Commits, commit)
+	for field, fieldTerms := range terms {
+		for _, term := range fieldTerms {
+			key := field + ":" + term
+			index.data.Postings[key] = append(index.data.Postings[key], id)
+		}
+	}
+}
+
+// BuildHistoryIndex indexes the history of refs into the file at path. An
+// existing index is updated with the commits added since it was last built,
+// commits which are no longer reachable stay indexed until the file is
+// removed and rebuilt.
+func (git GitCLIWrapper) BuildHistoryIndex(refs []string, path string) (*HistoryIndex, error) {
+	git.logger.Debugf("building history index of %s at %s", refs, path)
+
+	index, err := OpenHistoryIndex(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tips := map[string]string{}
+	var revisions []string
+	for _, ref := range refs {
+		hash, err := git.runGit("rev-parse", "--verify", ref+"^{commit}")
+		if err != nil {
+			git.logger.Warnf("failed to resolve %s", ref)
+			return nil, err
+		}
+		tips[ref] = hash
+		revisions = append(revisions, hash)
+	}
+	for _, hash := range index.data.Tips {
+		if _, err := git.runGit("cat-file", "-e", hash+"^{commit}"); err == nil {
+			revisions = append(revisions, "^"+hash)
+		}
+	}
+
+	indexed := make(map[string]bool, len(index.data.Commits))
+	for _, commit := range index.data.Commits {
+		indexed[commit.Hash] = true
+	}
+
+	stdOut, err := git.runGitRawWithInput(strings.Join(revisions, "\n")+"\n",
+		"log", "--stdin", "--reverse", "--name-only", "--no-renames", "-z",
+		"--format=%x1e%H%x1f%an%x1f%ae%x1f%at%x1f%s%x1f%b%x1f%(trailers:only,unfold)%x1f")
+	if err != nil {
+		git.logger.Warnf("failed to read the history of %s", refs)
+		return nil, err
+	}
+
+	added := 0
+	for _, record := range strings.Split(string(stdOut), "\x1e") {
+		fields := strings.SplitN(record, "\x1f", 8)
+		if len(fields) != 8 || indexed[fields[0]] {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			git.logger.Warnf("date for commit %s came back in an unexpected format", fields[0])
+			return nil, err
+		}
+
+		commit := IndexedCommit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    time.Unix(timestamp, 0).UTC(),
+			Subject: fields[4],
+		}
+		terms := map[string][]string{
+			IndexFieldSubject: tokenize(fields[4]),
+			IndexFieldBody:    tokenize(fields[5]),
+			IndexFieldTrailer: tokenize(fields[6]),
+			IndexFieldAuthor:  tokenize(fields[1] + " " + fields[2]),
+		}
+		for _, filePath := range strings.Split(fields[7], "\x00") {
+			if filePath = strings.Trim(filePath, "\n"); filePath != "" {
+				terms[IndexFieldPath] = append(terms[IndexFieldPath], pathTerms(filePath)...)
+			}
+		}
+		terms[IndexFieldPath] = uniqueStrings(terms[IndexFieldPath])
+
+		index.add(commit, terms)
+		indexed[commit.Hash] = true
+		added++
+	}
+
+	for ref, hash := range tips {
+		index.data.Tips[ref] = hash
+	}
+	if err := index.save(); err != nil {
+		git.logger.Warnf("failed to write the history index to %s", path)
+		return nil, err
+	}
+	git.logger.Debugf("indexed %d new commits, %d in total", added, len(index.data.Commits))
+
+	return index, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// lookup returns the commit ids for a term in one of the fields, a trailing
+// * matches every term with that prefix.
+func (index *HistoryIndex) lookup(fields []string, term string) map[int]bool {
+	ids := map[int]bool{}
+	prefix := strings.HasSuffix(term, "*")
+	term = strings.TrimSuffix(term, "*")
+	for _, field := range fields {
+		if !prefix {
+			for _, id := range index.data.Postings[field+":"+term] {
+				ids[id] = true
+			}
+			continue
+		}
+		for key, postings := range index.data.Postings {
+			if strings.HasPrefix(key, field+":"+term) {
+				for _, id := range postings {
+					ids[id] = true
+				}
+			}
+		}
+	}
+	return ids
+}
+
+func parseQueryDate(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, use YYYY-MM-DD or RFC 3339", value)
+}
+
+// splitQuery splits a query on spaces, keeping double quoted values such as
+// author:"Jane Doe" together.
+func splitQuery(query string) []string {
+	var parts []string
+	sb := strings.Builder{}
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if sb.Len() > 0 {
+				parts = append(parts, sb.String())
+				sb.Reset()
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	if sb.Len() > 0 {
+		parts = append(parts, sb.String())
+	}
+	return parts
+}
+
+// Search finds commits matching every term of the query, newest first. Terms
+// match the subject, body and trailers unless prefixed with a field such as
+// author:, path:, subject:, body: or trailer:. after: and before: take a
+// date and limit the author date range, a trailing * matches a prefix.
+func (index *HistoryIndex) Search(query string) ([]IndexedCommit, error) {
+	var after, before time.Time
+	var matched map[int]bool
+	intersect := func(ids map[int]bool) {
+		if matched == nil {
+			matched = ids
+			return
+		}
+		for id := range matched {
+			if !ids[id] {
+				delete(matched, id)
+			}
+		}
+	}
+
+	for _, part := range splitQuery(query) {
+		field, value, found := strings.Cut(part, ":")
+		if !found {
+			field, value = "", part
+		}
+
+		switch field {
+		case "after", "before":
+			date, err := parseQueryDate(value)
+			if err != nil {
+				return nil, err
+			}
+			if field == "after" {
+				after = date
+			} else {
+				before = date
+			}
+			continue
+		case IndexFieldPath:
+			intersect(index.lookup([]string{IndexFieldPath}, strings.ToLower(strings.Trim(value, "/"))))
+			continue
+		}
+
+		fields := messageFields
+		switch field {
+		case "":
+		case IndexFieldSubject, IndexFieldBody, IndexFieldTrailer, IndexFieldAuthor:
+			fields = []string{field}
+		default:
+			return nil, fmt.Errorf("unknown search field %q", field)
+		}
+
+		terms := tokenize(value)
+		if len(terms) == 0 {
+			// nothing shorter than a token is indexed, so such a term can
+			// not match any commit
+			intersect(map[int]bool{})
+			continue
+		}
+		if strings.HasSuffix(value, "*") {
+			terms[len(terms)-1] += "*"
+		}
+		for _, term := range terms {
+			intersect(index.lookup(fields, term))
+		}
+	}
+
+	var results []IndexedCommit
+	for id, commit := range index.data.Commits {
+		if matched != nil && !matched[id] {
+			continue
+		}
+		if !after.IsZero() && commit.Date.Before(after) {
+			continue
+		}
+		if !before.IsZero() && !commit.Date.Before(before) {
+			continue
+		}
+		results = append(results, commit)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Date.After(results[j].Date)
+	})
+	return results, nil
+}
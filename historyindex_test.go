@@ -0,0 +1,39 @@
+package gitcliwrapper
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHistoryIndexSearch(t *testing.T) {
+	git, dir := newTestRepository(t)
+	runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "Fix parser crash")
+	runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "Add x support")
+
+	index, err := git.BuildHistoryIndex([]string{"main"}, filepath.Join(t.TempDir(), "index.json"))
+	if err != nil {
+		t.Fatalf("BuildHistoryIndex: %s", err)
+	}
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"parser", 1},
+		{"pars*", 1},
+		{"subject:support", 1},
+		{"x", 0},
+		{"--", 0},
+		{"parser x", 0},
+		{"author:test", 3},
+	}
+	for _, test := range tests {
+		results, err := index.Search(test.query)
+		if err != nil {
+			t.Fatalf("Search(%q): %s", test.query, err)
+		}
+		if len(results) != test.expected {
+			t.Errorf("Search(%q): expected %d commits, got %d", test.query, test.expected, len(results))
+		}
+	}
+}
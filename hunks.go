@@ -0,0 +1,252 @@
+package gitcliwrapper
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@(.*)$`)
+
+type Hunk struct {
+	// ID is derived from the path and the content of the hunk, so it stays
+	// the same while other hunks are staged or unstaged
+	ID       string
+	Path     string
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Section is the function context git shows after the hunk header
+	Section string
+	// Lines are the hunk lines including their leading ' ', '+' or '-'
+	Lines []string
+
+	fileHeader []string
+}
+
+func (hunk Hunk) header(oldStart, newStart int) string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@%s", oldStart, hunk.OldLines, newStart, hunk.NewLines, hunk.Section)
+}
+
+func hunkID(path string, lines []string, seen map[string]int) string {
+	sum := sha1.Sum([]byte(path + "\x00" + strings.Join(lines, "\n")))
+	id := fmt.Sprintf("%x", sum)[:12]
+	seen[id]++
+	if seen[id] > 1 {
+		// identical hunks in one file are told apart by their order
+		id = fmt.Sprintf("%s-%d", id, seen[id])
+	}
+	return id
+}
+
+// diffGitPath reads the path from a diff --git line, which without renames
+// names the same path twice so it can be split even if it has spaces.
+func diffGitPath(line string) string {
+	paths := strings.TrimPrefix(line, "diff --git ")
+	if len(paths)%2 == 0 {
+		return ""
+	}
+	oldPath, newPath := paths[:len(paths)/2], paths[len(paths)/2+1:]
+	if !strings.HasPrefix(oldPath, "a/") || newPath != "b/"+oldPath[2:] {
+		return ""
+	}
+	return oldPath[2:]
+}
+
+// diffHeaderPath reads the path from a ---/+++ line, git adds a tab after
+// names with spaces and quotes names with special characters.
+func diffHeaderPath(name, prefix string) string {
+	name = strings.TrimSuffix(name, "\t")
+	if strings.HasPrefix(name, `"`) {
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			name = unquoted
+		}
+	}
+	return strings.TrimPrefix(name, prefix)
+}
+
+// parseHunks splits a unified diff into hunks, keeping the file headers so
+// patches can be rebuilt from any subset of them.
+func parseHunks(diff string) []Hunk {
+	var hunks []Hunk
+	var fileHeader []string
+	var current *Hunk
+	path := ""
+	seen := map[string]int{}
+
+	finish := func() {
+		if current != nil {
+			current.ID = hunkID(current.Path, current.Lines, seen)
+			hunks = append(hunks, *current)
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			finish()
+			fileHeader = []string{line}
+			path = diffGitPath(line)
+		case current == nil && strings.HasPrefix(line, "--- "):
+			fileHeader = append(fileHeader, line)
+			if name := strings.TrimPrefix(line, "--- "); name != "/dev/null" {
+				path = diffHeaderPath(name, "a/")
+			}
+		case current == nil && strings.HasPrefix(line, "+++ "):
+			fileHeader = append(fileHeader, line)
+			if name := strings.TrimPrefix(line, "+++ "); name != "/dev/null" {
+				path = diffHeaderPath(name, "b/")
+			}
+		case strings.HasPrefix(line, "@@ "):
+			finish()
+			match := hunkHeaderPattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			current = &Hunk{Path: path, Section: match[5], fileHeader: fileHeader}
+			current.OldStart, _ = strconv.Atoi(match[1])
+			current.OldLines = 1
+			if match[2] != "" {
+				current.OldLines, _ = strconv.Atoi(match[2])
+			}
+			current.NewStart, _ = strconv.Atoi(match[3])
+			current.NewLines = 1
+			if match[4] != "" {
+				current.NewLines, _ = strconv.Atoi(match[4])
+			}
+		case current != nil:
+			current.Lines = append(current.Lines, line)
+		default:
+			fileHeader = append(fileHeader, line)
+		}
+	}
+	finish()
+
+	return hunks
+}
+
+// ListHunks lists the hunks of the changes to path, or to every path when
+// it is empty. Unstaged hunks are between the index and the working tree,
+// staged hunks between HEAD and the index.
+func (git GitCLIWrapper) ListHunks(path string, staged bool) ([]Hunk, error) {
+	git.logger.Debugf("listing hunks of %s, staged: %t", path, staged)
+
+	args := []string{"-c", "core.quotePath=false", "diff", "--no-color", "--no-ext-diff", "--no-renames", "--no-relative", "--src-prefix=a/", "--dst-prefix=b/"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	args = append(args, "--")
+	if path != "" {
+		args = append(args, path)
+	}
+
+	stdOut, err := git.runGitRaw(args...)
+	if err != nil {
+		git.logger.Warnf("failed to diff %s", path)
+		return nil, err
+	}
+	return parseHunks(string(stdOut)), nil
+}
+
+// buildPatch joins the selected hunks into a patch, the positions of later
+// hunks in a file are moved by the size change of the hunks before them.
+func buildPatch(hunks []Hunk, selected map[string]bool, reverse bool) string {
+	sb := strings.Builder{}
+	lastPath := ""
+	offset := 0
+	for _, hunk := range hunks {
+		if !selected[hunk.ID] {
+			continue
+		}
+		if hunk.Path != lastPath {
+			sb.WriteString(strings.Join(hunk.fileHeader, "\n") + "\n")
+			lastPath = hunk.Path
+			offset = 0
+		}
+
+		// the side the patch is applied to is untouched by the earlier hunks,
+		// the other side is moved by their size change
+		oldStart, newStart := hunk.OldStart, hunk.NewStart
+		if reverse {
+			oldStart = hunk.NewStart + offset
+			if hunk.NewLines == 0 {
+				oldStart++
+			}
+			if hunk.OldLines == 0 {
+				oldStart--
+			}
+			offset += hunk.OldLines - hunk.NewLines
+		} else {
+			newStart = hunk.OldStart + offset
+			if hunk.OldLines == 0 {
+				newStart++
+			}
+			if hunk.NewLines == 0 {
+				newStart--
+			}
+			offset += hunk.NewLines - hunk.OldLines
+		}
+
+		sb.WriteString(hunk.header(oldStart, newStart) + "\n")
+		sb.WriteString(strings.Join(hunk.Lines, "\n") + "\n")
+	}
+	return sb.String()
+}
+
+func (git GitCLIWrapper) applyHunks(ids []string, staged bool) error {
+	hunks, err := git.ListHunks("", staged)
+	if err != nil {
+		return err
+	}
+
+	selected := map[string]bool{}
+	for _, id := range ids {
+		selected[id] = true
+	}
+	for _, hunk := range hunks {
+		delete(selected, hunk.ID)
+	}
+	if len(selected) > 0 {
+		missing := make([]string, 0, len(selected))
+		for id := range selected {
+			missing = append(missing, id)
+		}
+		return fmt.Errorf("unknown hunks %s", strings.Join(missing, ", "))
+	}
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	topLevel, err := git.runGit("rev-parse", "--show-toplevel")
+	if err != nil {
+		git.logger.Warn("failed to find the top of the working tree")
+		return err
+	}
+	args := []string{"-C", topLevel, "apply", "--cached", "--whitespace=nowarn"}
+	if staged {
+		args = append(args, "--reverse")
+	}
+	if _, err := git.runGitRawWithInput(buildPatch(hunks, selected, staged), args...); err != nil {
+		git.logger.Warnf("failed to apply hunks %s to the index", ids)
+		return err
+	}
+	return nil
+}
+
+// StageHunks adds the given unstaged hunks to the index, like picking them
+// in git add -p.
+func (git GitCLIWrapper) StageHunks(ids []string) error {
+	git.logger.Debugf("staging hunks %s", ids)
+	return git.applyHunks(ids, false)
+}
+
+// UnstageHunks removes the given staged hunks from the index, leaving the
+// working tree as it is.
+func (git GitCLIWrapper) UnstageHunks(ids []string) error {
+	git.logger.Debugf("unstaging hunks %s", ids)
+	return git.applyHunks(ids, true)
+}
@@ -0,0 +1,138 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func numberedLines(count int) []string {
+	lines := make([]string, count)
+	for idx := range lines {
+		lines[idx] = fmt.Sprintf("line %d", idx+1)
+	}
+	return lines
+}
+
+// editLines applies the edits whose index is selected to the original 30
+// lines: two lines added after line 3, line 15 deleted and line 27 replaced.
+func editLines(selected ...int) string {
+	original := numberedLines(30)
+	isSelected := map[int]bool{}
+	for _, idx := range selected {
+		isSelected[idx] = true
+	}
+
+	var lines []string
+	for idx, line := range original {
+		switch {
+		case idx == 14 && isSelected[1]:
+			continue
+		case idx == 26 && isSelected[2]:
+			lines = append(lines, "line 27 changed")
+			continue
+		}
+		lines = append(lines, line)
+		if idx == 2 && isSelected[0] {
+			lines = append(lines, "added a", "added b")
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestStageAndUnstageHunks(t *testing.T) {
+	git, dir := newTestRepository(t)
+	path := filepath.Join(dir, "file with space.txt")
+	if err := os.WriteFile(path, []byte(editLines()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runTestGit(t, dir, "add", ".")
+	runTestGit(t, dir, "commit", "--quiet", "-m", "add file")
+	if err := os.WriteFile(path, []byte(editLines(0, 1, 2)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	hunks, err := git.ListHunks("", false)
+	if err != nil {
+		t.Fatalf("ListHunks: %s", err)
+	}
+	if len(hunks) != 3 {
+		t.Fatalf("expected three hunks, got %d", len(hunks))
+	}
+	for _, hunk := range hunks {
+		if hunk.Path != "file with space.txt" {
+			t.Fatalf("expected the path without git's trailing tab, got %q", hunk.Path)
+		}
+	}
+
+	// staging the later hunks alone moves them up by the skipped addition
+	if err := git.StageHunks([]string{hunks[1].ID, hunks[2].ID}); err != nil {
+		t.Fatalf("StageHunks: %s", err)
+	}
+	if staged := runTestGit(t, dir, "show", ":file with space.txt") + "\n"; staged != editLines(1, 2) {
+		t.Fatalf("unexpected index content after staging:\n%s", staged)
+	}
+
+	remaining, err := git.ListHunks("", false)
+	if err != nil {
+		t.Fatalf("ListHunks: %s", err)
+	}
+	if len(remaining) != 1 || remaining[0].ID != hunks[0].ID {
+		t.Fatalf("expected only the first hunk to remain unstaged with the same id, got %v", remaining)
+	}
+
+	staged, err := git.ListHunks("", true)
+	if err != nil {
+		t.Fatalf("ListHunks: %s", err)
+	}
+	if len(staged) != 2 {
+		t.Fatalf("expected two staged hunks, got %d", len(staged))
+	}
+	if err := git.UnstageHunks([]string{staged[0].ID}); err != nil {
+		t.Fatalf("UnstageHunks: %s", err)
+	}
+	if index := runTestGit(t, dir, "show", ":file with space.txt") + "\n"; index != editLines(2) {
+		t.Fatalf("unexpected index content after unstaging:\n%s", index)
+	}
+
+	if err := git.StageHunks([]string{"unknown"}); err == nil {
+		t.Fatal("expected unknown hunk ids to be refused")
+	}
+}
+
+func TestBuildPatchOffsets(t *testing.T) {
+	// a adds two lines, b removes one and c inserts one after line 24
+	hunks := []Hunk{
+		{ID: "a", Path: "f", OldStart: 3, OldLines: 3, NewStart: 3, NewLines: 5, fileHeader: []string{"diff --git a/f b/f"}},
+		{ID: "b", Path: "f", OldStart: 12, OldLines: 7, NewStart: 14, NewLines: 6, fileHeader: []string{"diff --git a/f b/f"}},
+		{ID: "c", Path: "f", OldStart: 24, OldLines: 0, NewStart: 26, NewLines: 1, fileHeader: []string{"diff --git a/f b/f"}},
+	}
+
+	tests := []struct {
+		selected []string
+		reverse  bool
+		headers  []string
+	}{
+		{[]string{"b", "c"}, false, []string{"@@ -12,7 +12,6 @@", "@@ -24,0 +24,1 @@"}},
+		{[]string{"a", "c"}, false, []string{"@@ -3,3 +3,5 @@", "@@ -24,0 +27,1 @@"}},
+		{[]string{"b", "c"}, true, []string{"@@ -14,7 +14,6 @@", "@@ -26,0 +26,1 @@"}},
+		{[]string{"a", "c"}, true, []string{"@@ -3,3 +3,5 @@", "@@ -23,0 +26,1 @@"}},
+	}
+	for _, test := range tests {
+		selected := map[string]bool{}
+		for _, id := range test.selected {
+			selected[id] = true
+		}
+		var headers []string
+		for _, line := range strings.Split(buildPatch(hunks, selected, test.reverse), "\n") {
+			if strings.HasPrefix(line, "@@") {
+				headers = append(headers, line)
+			}
+		}
+		if strings.Join(headers, "|") != strings.Join(test.headers, "|") {
+			t.Errorf("selected %v reverse %t: expected %v, got %v", test.selected, test.reverse, test.headers, headers)
+		}
+	}
+}
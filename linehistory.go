@@ -0,0 +1,90 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type LineRange struct {
+	Start int
+	End   int
+	// FuncName selects the lines of a function instead of Start and End, it
+	// is matched by git as a regular expression against the function header
+	FuncName string
+}
+
+type LineHistoryEntry struct {
+	Hash    string
+	Author  string
+	Date    time.Time
+	Subject string
+	// Path is the file path at this commit, as git log -L follows renames
+	Path string
+	// Hunk is the diff this commit applied to the tracked lines
+	Hunk string
+}
+
+func (lines LineRange) logArgument(path string) (string, error) {
+	if lines.FuncName != "" {
+		return fmt.Sprintf("-L:%s:%s", lines.FuncName, path), nil
+	}
+	if lines.Start <= 0 || lines.End < lines.Start {
+		return "", fmt.Errorf("invalid line range %d,%d", lines.Start, lines.End)
+	}
+	return fmt.Sprintf("-L%d,%d:%s", lines.Start, lines.End, path), nil
+}
+
+func (git GitCLIWrapper) LineHistory(path string, lines LineRange, ref string) ([]LineHistoryEntry, error) {
+	git.logger.Debugf("getting line history for %s in %s", path, ref)
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	rangeArg, err := lines.logArgument(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stdOut, err := git.runGitRaw("log", "--no-color", "--src-prefix=a/", "--dst-prefix=b/", "--format=%x01%H%x00%an%x00%aI%x00%s", rangeArg, ref, "--")
+	if err != nil {
+		git.logger.Warnf("failed to get the line history for %s", path)
+		return nil, err
+	}
+
+	var entries []LineHistoryEntry
+	for _, record := range strings.Split(string(stdOut), "\x01") {
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+		header, hunk, _ := strings.Cut(record, "\n")
+		fields := strings.Split(header, "\x00")
+		if len(fields) != 4 {
+			return nil, errors.New("git log -L returned an unexpected format")
+		}
+		date, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			git.logger.Warnf("author date for %s came back in an unexpected format", fields[0])
+			return nil, err
+		}
+
+		entry := LineHistoryEntry{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Date:    date,
+			Subject: fields[3],
+			Path:    path,
+			Hunk:    strings.Trim(hunk, "\n") + "\n",
+		}
+		for _, line := range strings.Split(hunk, "\n") {
+			if name := strings.TrimPrefix(line, "+++ "); name != line && name != "/dev/null" {
+				entry.Path = diffHeaderPath(name, "b/")
+				break
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,34 @@
+package gitcliwrapper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLineHistoryPathWithDiffPrefixConfig(t *testing.T) {
+	for _, config := range [][]string{{"diff.noprefix", "true"}, {"diff.mnemonicPrefix", "true"}} {
+		t.Run(config[0], func(t *testing.T) {
+			git, dir := newTestRepository(t)
+			runTestGit(t, dir, "config", config[0], config[1])
+			if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("one\ntwo\nthree\nfour\nfive\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			runTestGit(t, dir, "add", ".")
+			runTestGit(t, dir, "commit", "--quiet", "-m", "add file")
+			runTestGit(t, dir, "mv", "old.txt", "new.txt")
+			if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("one changed\ntwo\nthree\nfour\nfive\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			runTestGit(t, dir, "commit", "--quiet", "-am", "rename file")
+
+			entries, err := git.LineHistory("new.txt", LineRange{Start: 1, End: 2}, "HEAD")
+			if err != nil {
+				t.Fatalf("LineHistory: %s", err)
+			}
+			if len(entries) != 2 || entries[0].Path != "new.txt" || entries[1].Path != "old.txt" {
+				t.Fatalf("expected the history to follow the rename, got %v", entries)
+			}
+		})
+	}
+}
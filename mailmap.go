@@ -0,0 +1,346 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const githubNoreplyDomain = "users.noreply.github.com"
+
+type MailmapOptions struct {
+	// DomainAliases maps an alias domain to its canonical domain, such as
+	// corp.example.com to example.com
+	DomainAliases map[string]string
+	// Ref is where the existing .mailmap is read from, defaults to HEAD
+	Ref string
+}
+
+type MailmapIdentity struct {
+	Name    string
+	Email   string
+	Commits int
+}
+
+type MailmapCluster struct {
+	Canonical  MailmapIdentity
+	Identities []MailmapIdentity
+}
+
+// MailmapEntry is a single .mailmap line, an empty ProperEmail keeps the
+// commit email and an empty CommitName matches any name.
+type MailmapEntry struct {
+	ProperName  string
+	ProperEmail string
+	CommitName  string
+	CommitEmail string
+}
+
+func (entry MailmapEntry) String() string {
+	parts := []string{}
+	if entry.ProperName != "" {
+		parts = append(parts, entry.ProperName)
+	}
+	if entry.ProperEmail != "" {
+		parts = append(parts, "<"+entry.ProperEmail+">")
+	}
+	if entry.CommitName != "" {
+		parts = append(parts, entry.CommitName)
+	}
+	return strings.Join(append(parts, "<"+entry.CommitEmail+">"), " ")
+}
+
+type MailmapSuggestion struct {
+	Clusters []MailmapCluster
+	// Added are the entries missing from the existing .mailmap
+	Added []MailmapEntry
+	// Content is the existing .mailmap with Added appended
+	Content string
+}
+
+// parseMailmap reads the entries of a .mailmap, comments and lines which
+// are not entries are skipped.
+func parseMailmap(content string) []MailmapEntry {
+	var entries []MailmapEntry
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		firstName, rest, found := strings.Cut(line, "<")
+		if !found {
+			continue
+		}
+		firstEmail, rest, found := strings.Cut(rest, ">")
+		if !found {
+			continue
+		}
+
+		entry := MailmapEntry{ProperName: strings.TrimSpace(firstName)}
+		secondName, rest, found := strings.Cut(rest, "<")
+		secondEmail, _, closed := strings.Cut(rest, ">")
+		if found && closed {
+			entry.ProperEmail = strings.TrimSpace(firstEmail)
+			entry.CommitName = strings.TrimSpace(secondName)
+			entry.CommitEmail = strings.TrimSpace(secondEmail)
+		} else {
+			entry.CommitEmail = strings.TrimSpace(firstEmail)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// resolveMailmap maps an identity the way git does, entries matching the
+// name and email win over entries matching only the email.
+func resolveMailmap(entries []MailmapEntry, name, email string) (string, string, bool) {
+	var match *MailmapEntry
+	for idx := range entries {
+		entry := &entries[idx]
+		if !strings.EqualFold(entry.CommitEmail, email) {
+			continue
+		}
+		if entry.CommitName == "" {
+			match = entry
+			continue
+		}
+		if strings.EqualFold(entry.CommitName, name) {
+			match = entry
+			break
+		}
+	}
+	if match == nil {
+		return name, email, false
+	}
+	if match.ProperName != "" {
+		name = match.ProperName
+	}
+	if match.ProperEmail != "" {
+		email = match.ProperEmail
+	}
+	return name, email, true
+}
+
+func normalizeName(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}), " ")
+}
+
+// normalizeEmail lower cases an email, drops +tags and maps domain aliases
+// to their canonical domain.
+func normalizeEmail(email string, aliases map[string]string) string {
+	local, domain, found := strings.Cut(strings.ToLower(email), "@")
+	if !found {
+		return strings.ToLower(email)
+	}
+	if domain == githubNoreplyDomain {
+		if _, user, found := strings.Cut(local, "+"); found {
+			local = user
+		}
+	}
+	local, _, _ = strings.Cut(local, "+")
+	if canonical, ok := aliases[domain]; ok {
+		domain = strings.ToLower(canonical)
+	}
+	return local + "@" + domain
+}
+
+// SuggestMailmap clusters the author and committer identities of a range by
+// normalised name and email, and proposes .mailmap entries mapping every
+// identity of a cluster to one canonical identity. Mappings already in the
+// .mailmap at opts.Ref are respected and decide the canonical identity.
+func (git GitCLIWrapper) SuggestMailmap(commitRange string, opts MailmapOptions) (*MailmapSuggestion, error) {
+	git.logger.Debugf("suggesting mailmap entries for %s", commitRange)
+
+	aliases := map[string]string{}
+	for alias, canonical := range opts.DomainAliases {
+		aliases[strings.ToLower(alias)] = canonical
+	}
+	ref := opts.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	existing := ""
+	if _, err := git.runGit("cat-file", "-e", ref+":.mailmap"); err == nil {
+		if existing, err = git.runGit("show", ref+":.mailmap"); err != nil {
+			git.logger.Warnf("failed to read .mailmap at %s", ref)
+			return nil, err
+		}
+	}
+	entries := parseMailmap(existing)
+
+	stdOut, err := git.runGit("log", "--format=%an%x00%ae%n%cn%x00%ce", commitRange)
+	if err != nil {
+		git.logger.Warnf("failed to list identities in %s", commitRange)
+		return nil, err
+	}
+
+	var identities []MailmapIdentity
+	byIdentity := map[string]int{}
+	for _, line := range strings.Split(stdOut, "\n") {
+		name, email, found := strings.Cut(line, "\x00")
+		if !found {
+			continue
+		}
+		key := name + "\x00" + strings.ToLower(email)
+		if idx, ok := byIdentity[key]; ok {
+			identities[idx].Commits++
+			continue
+		}
+		byIdentity[key] = len(identities)
+		identities = append(identities, MailmapIdentity{Name: name, Email: email, Commits: 1})
+	}
+
+	parents := make([]int, len(identities))
+	for idx := range parents {
+		parents[idx] = idx
+	}
+	var find func(int) int
+	find = func(idx int) int {
+		if parents[idx] != idx {
+			parents[idx] = find(parents[idx])
+		}
+		return parents[idx]
+	}
+	byKey := map[string]int{}
+	link := func(key string, idx int) {
+		if other, ok := byKey[key]; ok {
+			parents[find(idx)] = find(other)
+			return
+		}
+		byKey[key] = idx
+	}
+	resolved := make([]MailmapIdentity, len(identities))
+	mapped := make([]bool, len(identities))
+	for idx, identity := range identities {
+		name, email, ok := resolveMailmap(entries, identity.Name, identity.Email)
+		resolved[idx], mapped[idx] = MailmapIdentity{Name: name, Email: email}, ok
+
+		link("email:"+normalizeEmail(identity.Email, aliases), idx)
+		link("email:"+normalizeEmail(email, aliases), idx)
+		// single word names such as "admin" are too common to go by
+		if normalized := normalizeName(identity.Name); strings.Contains(normalized, " ") {
+			link("name:"+normalized, idx)
+		}
+	}
+
+	clusters := map[int][]int{}
+	for idx := range identities {
+		root := find(idx)
+		clusters[root] = append(clusters[root], idx)
+	}
+
+	suggestion := &MailmapSuggestion{}
+	for _, members := range clusters {
+		if len(members) < 2 {
+			continue
+		}
+		canonical := canonicalIdentity(identities, resolved, mapped, members, aliases)
+		cluster := MailmapCluster{Canonical: canonical}
+		for _, idx := range members {
+			identity := identities[idx]
+			cluster.Identities = append(cluster.Identities, identity)
+			if resolved[idx].Name == canonical.Name && strings.EqualFold(resolved[idx].Email, canonical.Email) {
+				continue
+			}
+			entry := MailmapEntry{ProperName: canonical.Name, ProperEmail: canonical.Email, CommitEmail: identity.Email}
+			if identity.Name != canonical.Name {
+				entry.CommitName = identity.Name
+			}
+			suggestion.Added = append(suggestion.Added, entry)
+		}
+		sort.Slice(cluster.Identities, func(i, j int) bool {
+			return cluster.Identities[i].Commits > cluster.Identities[j].Commits
+		})
+		suggestion.Clusters = append(suggestion.Clusters, cluster)
+	}
+	sort.Slice(suggestion.Clusters, func(i, j int) bool {
+		return suggestion.Clusters[i].Canonical.Name < suggestion.Clusters[j].Canonical.Name
+	})
+	sort.Slice(suggestion.Added, func(i, j int) bool {
+		return suggestion.Added[i].String() < suggestion.Added[j].String()
+	})
+
+	suggestion.Content = existing
+	if len(suggestion.Added) > 0 {
+		sb := strings.Builder{}
+		if existing != "" {
+			sb.WriteString(strings.TrimRight(existing, "\n") + "\n")
+		}
+		for _, entry := range suggestion.Added {
+			sb.WriteString(entry.String() + "\n")
+		}
+		suggestion.Content = sb.String()
+	}
+
+	return suggestion, nil
+}
+
+// canonicalIdentity picks what a cluster maps to. Identities existing
+// entries map to come first, then emails on a canonical domain, then the
+// most used name and email.
+func canonicalIdentity(identities, resolved []MailmapIdentity, mapped []bool, members []int, aliases map[string]string) MailmapIdentity {
+	type candidate struct {
+		mapped    bool
+		preferred bool
+		commits   int
+	}
+	better := func(a, b candidate) bool {
+		if a.mapped != b.mapped {
+			return a.mapped
+		}
+		if a.preferred != b.preferred {
+			return a.preferred
+		}
+		return a.commits > b.commits
+	}
+
+	names := map[string]*candidate{}
+	emails := map[string]*candidate{}
+	for _, idx := range members {
+		name, email := resolved[idx].Name, resolved[idx].Email
+		if names[name] == nil {
+			names[name] = &candidate{preferred: true}
+		}
+		if emails[email] == nil {
+			_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+			_, alias := aliases[domain]
+			emails[email] = &candidate{preferred: !alias && domain != githubNoreplyDomain}
+		}
+		for _, c := range []*candidate{names[name], emails[email]} {
+			c.mapped = c.mapped || mapped[idx]
+			c.commits += identities[idx].Commits
+		}
+	}
+
+	pick := func(candidates map[string]*candidate) string {
+		best := ""
+		for value, c := range candidates {
+			if best == "" || better(*c, *candidates[best]) || (!better(*candidates[best], *c) && value < best) {
+				best = value
+			}
+		}
+		return best
+	}
+	return MailmapIdentity{Name: pick(names), Email: pick(emails)}
+}
+
+// WriteMailmap writes the suggested .mailmap to the top of the working tree
+// and commits it.
+func (git GitCLIWrapper) WriteMailmap(suggestion *MailmapSuggestion, message string) (*string, error) {
+	topLevel, err := git.runGit("rev-parse", "--show-toplevel")
+	if err != nil {
+		git.logger.Warn("failed to find the top of the working tree")
+		return nil, err
+	}
+	mailmapPath := filepath.Join(topLevel, ".mailmap")
+	if err := os.WriteFile(mailmapPath, []byte(suggestion.Content), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", mailmapPath, err)
+	}
+	return git.Commit(message, mailmapPath)
+}
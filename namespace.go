@@ -0,0 +1,237 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const namespaceRefPrefix = "refs/namespaces/"
+
+// namespacePrefix expands a namespace such as a/b into the ref prefix git
+// uses for it, refs/namespaces/a/refs/namespaces/b/.
+func namespacePrefix(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	sb := strings.Builder{}
+	for _, component := range strings.Split(namespace, "/") {
+		sb.WriteString(namespaceRefPrefix + component + "/")
+	}
+	return sb.String()
+}
+
+func (git GitCLIWrapper) validateNamespace(namespace string) error {
+	for _, component := range strings.Split(namespace, "/") {
+		if component == "" {
+			return fmt.Errorf("namespace %q has an empty component", namespace)
+		}
+	}
+	if _, err := git.runGit("check-ref-format", namespacePrefix(namespace)+"HEAD"); err != nil {
+		return fmt.Errorf("%q is not a valid namespace", namespace)
+	}
+	return nil
+}
+
+// WithNamespace returns a copy of the wrapper which works inside a ref
+// namespace, nested namespaces are separated with a slash. Remote ref
+// listings, ref updates and push targets are mapped into the namespace by
+// the wrapper itself rather than through GIT_NAMESPACE, so isolation holds
+// over any transport.
+func (git GitCLIWrapper) WithNamespace(namespace string) (*GitCLIWrapper, error) {
+	namespace = strings.Trim(namespace, "/")
+	if err := git.validateNamespace(namespace); err != nil {
+		return nil, err
+	}
+	git.namespace = namespace
+	return &git, nil
+}
+
+func (git GitCLIWrapper) Namespace() string {
+	return git.namespace
+}
+
+func (git GitCLIWrapper) namespacedRef(ref string) string {
+	if git.namespace == "" {
+		return ref
+	}
+	if ref != "HEAD" && !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
+	}
+	return namespacePrefix(git.namespace) + ref
+}
+
+// stripNamespace turns a ref listed on the remote back into the name it has
+// inside the wrapper's namespace.
+func (git GitCLIWrapper) stripNamespace(ref string) string {
+	return strings.TrimPrefix(ref, namespacePrefix(git.namespace))
+}
+
+// namespacedRefSpec maps the destination of a push refspec into the
+// namespace, short destinations take the kind of ref of the source. A
+// refspec without a destination pushes to the ref its source resolves to,
+// so HEAD pushes to the checked out branch.
+func (git GitCLIWrapper) namespacedRefSpec(refSpec string) string {
+	if git.namespace == "" {
+		return refSpec
+	}
+
+	force := ""
+	if strings.HasPrefix(refSpec, "+") {
+		force = "+"
+		refSpec = refSpec[1:]
+	}
+	src, dst, found := strings.Cut(refSpec, ":")
+	fullName := ""
+	if src != "" {
+		fullName, _ = git.runGit("rev-parse", "--symbolic-full-name", src)
+	}
+	if !found {
+		dst = src
+		if strings.HasPrefix(fullName, "refs/") {
+			dst = fullName
+		}
+	}
+	if dst != "" && !strings.HasPrefix(dst, "refs/") {
+		kind := "refs/heads/"
+		if strings.HasPrefix(fullName, "refs/tags/") {
+			kind = "refs/tags/"
+		}
+		dst = kind + dst
+	}
+
+	return force + src + ":" + git.namespacedRef(dst)
+}
+
+func (git GitCLIWrapper) namespacePush(refSpecs []string, opts PushOptions) ([]string, PushOptions) {
+	if git.namespace == "" {
+		return refSpecs, opts
+	}
+
+	namespaced := make([]string, 0, len(refSpecs))
+	for _, refSpec := range refSpecs {
+		namespaced = append(namespaced, git.namespacedRefSpec(refSpec))
+	}
+	if opts.Leases != nil {
+		leases := make(map[string]string, len(opts.Leases))
+		for ref, hash := range opts.Leases {
+			leases[git.namespacedRef(ref)] = hash
+		}
+		opts.Leases = leases
+	}
+	return namespaced, opts
+}
+
+// UpdateRef points a ref at a hash, an empty oldHash only allows creating
+// the ref. The ref is placed in the wrapper's namespace when one is set.
+func (git GitCLIWrapper) UpdateRef(ref, hash, oldHash string) error {
+	ref = git.namespacedRef(ref)
+	git.logger.Debugf("updating %s to %s", ref, hash)
+	if _, err := git.runGit("update-ref", ref, hash, oldHash); err != nil {
+		git.logger.Warnf("failed to update %s", ref)
+		return err
+	}
+	return nil
+}
+
+// DeleteRef deletes a ref, when oldHash is set only if it still points there.
+func (git GitCLIWrapper) DeleteRef(ref, oldHash string) error {
+	ref = git.namespacedRef(ref)
+	git.logger.Debugf("deleting %s", ref)
+	args := []string{"update-ref", "-d", ref}
+	if oldHash != "" {
+		args = append(args, oldHash)
+	}
+	if _, err := git.runGit(args...); err != nil {
+		git.logger.Warnf("failed to delete %s", ref)
+		return err
+	}
+	return nil
+}
+
+// ListNamespaces lists the namespaces in the repository, inside the
+// wrapper's own namespace when one is set.
+func (git GitCLIWrapper) ListNamespaces() ([]string, error) {
+	git.logger.Debug("listing ref namespaces")
+	prefix := namespacePrefix(git.namespace) + namespaceRefPrefix
+	stdOut, err := git.runGit("for-each-ref", "--format=%(refname)", prefix)
+	if err != nil {
+		git.logger.Warn("failed to list ref namespaces")
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	for _, ref := range strings.Split(stdOut, "\n") {
+		if name, _, ok := strings.Cut(strings.TrimPrefix(ref, prefix), "/"); ok && strings.HasPrefix(ref, prefix) {
+			found[name] = true
+		}
+	}
+
+	namespaces := make([]string, 0, len(found))
+	for name := range found {
+		namespaces = append(namespaces, name)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// CreateNamespace creates a namespace with a single branch at hash and a
+// HEAD pointing to it, so the namespace can be cloned like a repository.
+func (git GitCLIWrapper) CreateNamespace(name, branch, hash string) error {
+	name = strings.Trim(name, "/")
+	if err := git.validateNamespace(name); err != nil {
+		return err
+	}
+	git.logger.Debugf("creating ref namespace %s", name)
+
+	prefix := namespacePrefix(git.namespace) + namespacePrefix(name)
+	if existing, err := git.runGit("for-each-ref", "--count=1", "--format=%(refname)", prefix); err != nil {
+		git.logger.Warn("failed to check for an existing namespace")
+		return err
+	} else if existing != "" {
+		return fmt.Errorf("namespace %s already exists", name)
+	}
+
+	branchRef := prefix + "refs/heads/" + branch
+	if _, err := git.runGit("update-ref", "-m", "create namespace "+name, branchRef, hash, ""); err != nil {
+		git.logger.Warnf("failed to create %s", branchRef)
+		return err
+	}
+	if _, err := git.runGit("symbolic-ref", prefix+"HEAD", branchRef); err != nil {
+		git.logger.Warnf("failed to point the HEAD of namespace %s at %s", name, branch)
+		return err
+	}
+	return nil
+}
+
+// DeleteNamespace deletes every ref in a namespace, including nested
+// namespaces, in a single transaction.
+func (git GitCLIWrapper) DeleteNamespace(name string) error {
+	name = strings.Trim(name, "/")
+	if err := git.validateNamespace(name); err != nil {
+		return err
+	}
+	git.logger.Debugf("deleting ref namespace %s", name)
+
+	prefix := namespacePrefix(git.namespace) + namespacePrefix(name)
+	stdOut, err := git.runGit("for-each-ref", "--format=%(refname)", prefix)
+	if err != nil {
+		git.logger.Warnf("failed to list the refs of namespace %s", name)
+		return err
+	}
+	if stdOut == "" {
+		return fmt.Errorf("namespace %s does not exist", name)
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("start\n")
+	for _, ref := range strings.Split(stdOut, "\n") {
+		sb.WriteString("delete " + ref + "\n")
+	}
+	sb.WriteString("commit\n")
+	if _, err := git.runGitRawWithInput(sb.String(), "update-ref", "--no-deref", "--stdin"); err != nil {
+		git.logger.Warnf("failed to delete the refs of namespace %s", name)
+		return err
+	}
+	return nil
+}
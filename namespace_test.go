@@ -0,0 +1,96 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"testing"
+)
+
+func newNamespacedTestRepository(t *testing.T) (*GitCLIWrapper, string, string) {
+	t.Helper()
+	remoteDir := t.TempDir()
+	runTestGit(t, remoteDir, "init", "--quiet", "--bare")
+	git, dir := newTestRepository(t)
+	runTestGit(t, dir, "remote", "add", "origin", remoteDir)
+	git.remote = "origin"
+
+	tenant, err := git.WithNamespace("tenant")
+	if err != nil {
+		t.Fatalf("WithNamespace: %s", err)
+	}
+	return tenant, dir, remoteDir
+}
+
+func TestNamespacedPushOfHead(t *testing.T) {
+	git, dir, remoteDir := newNamespacedTestRepository(t)
+
+	if err := git.Push([]string{"HEAD"}, PushOptions{}); err != nil {
+		t.Fatalf("Push: %s", err)
+	}
+	refs := runTestGit(t, remoteDir, "for-each-ref", "--format=%(refname)")
+	if expected := "refs/namespaces/tenant/refs/heads/main"; refs != expected {
+		t.Fatalf("expected only %s on the remote, got %q", expected, refs)
+	}
+	if hash := runTestGit(t, remoteDir, "rev-parse", "refs/namespaces/tenant/refs/heads/main"); hash != runTestGit(t, dir, "rev-parse", "HEAD") {
+		t.Fatalf("expected the namespaced branch to be at HEAD, got %s", hash)
+	}
+}
+
+func TestNamespacedArchiveAndTagDeletion(t *testing.T) {
+	git, dir, remoteDir := newNamespacedTestRepository(t)
+	runTestGit(t, dir, "branch", "feat")
+	runTestGit(t, dir, "tag", "v1.0.0")
+	if err := git.Push([]string{"feat", "v1.0.0"}, PushOptions{}); err != nil {
+		t.Fatalf("Push: %s", err)
+	}
+	// a tag of the same name outside the namespace must be left alone
+	runTestGit(t, dir, "push", "--quiet", "origin", "v1.0.0")
+
+	archived, err := git.ArchiveBranch("feat")
+	if err != nil {
+		t.Fatalf("ArchiveBranch: %s", err)
+	}
+	listed, err := git.ListArchived()
+	if err != nil {
+		t.Fatalf("ListArchived: %s", err)
+	}
+	if len(listed) != 1 || listed[0].Ref != archived.Ref || !listed[0].Remote {
+		t.Fatalf("expected %s to be listed on the remote, got %v", archived.Ref, listed)
+	}
+
+	if err := git.deleteTags([]DeletedTag{{Name: "v1.0.0"}}); err != nil {
+		t.Fatalf("deleteTags: %s", err)
+	}
+	refs := runTestGit(t, remoteDir, "for-each-ref", "--format=%(refname)", "refs/tags", "refs/namespaces/tenant/refs/tags")
+	if refs != "refs/tags/v1.0.0" {
+		t.Fatalf("expected only the tag outside the namespace to remain, got %q", refs)
+	}
+}
+
+func TestNamespacedOutboxLease(t *testing.T) {
+	git, dir, _ := newNamespacedTestRepository(t)
+	if err := git.Push([]string{"HEAD"}, PushOptions{}); err != nil {
+		t.Fatalf("Push: %s", err)
+	}
+	namespaced := runTestGit(t, dir, "rev-parse", "HEAD")
+	runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "outside the namespace")
+	runTestGit(t, dir, "push", "--quiet", "origin", "main")
+	runTestGit(t, dir, "fetch", "--quiet", "origin")
+
+	target, hash, ok := git.remoteTrackingLease("HEAD:refs/heads/main")
+	if !ok || target != "refs/heads/main" || hash != namespaced {
+		t.Fatalf("expected a lease on refs/heads/main at %s, got %s at %s", namespaced, target, hash)
+	}
+
+	// when the namespaced value can not be looked up the push is not queued
+	runTestGit(t, dir, "remote", "set-url", "origin", "https://unreachable.invalid/repo.git")
+	outbox, err := git.WithOutbox("")
+	if err != nil {
+		t.Fatalf("WithOutbox: %s", err)
+	}
+	if err := outbox.ForcePushSourceToTargetRef("HEAD", "main"); err == nil || errors.Is(err, ErrPushQueued) {
+		t.Fatalf("expected the force push to not be queued, got %v", err)
+	}
+	if pending, _ := outbox.PendingPushes(); len(pending) != 0 {
+		t.Fatalf("expected nothing queued, got %v", pending)
+	}
+}
@@ -0,0 +1,479 @@
+package gitcliwrapper
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxDiffLines      = 1000
+	defaultMaxDiffLineLength = 500
+	defaultMaxCommitEmails   = 100
+	defaultMessageIDDomain   = "gitcliwrapper.invalid"
+)
+
+const (
+	RefCreated      = "created"
+	RefUpdated      = "updated"
+	RefForceUpdated = "force-updated"
+	RefDeleted      = "deleted"
+)
+
+// RefUpdate is a single line as received by a post-receive hook, a hash of
+// all zeroes marks a created or deleted ref.
+type RefUpdate struct {
+	Ref     string
+	OldHash string
+	NewHash string
+}
+
+type RecipientRule struct {
+	// RefPattern is a glob matched against the full ref name
+	RefPattern string
+	Recipients []string
+}
+
+type NotificationOptions struct {
+	From string
+	// Repository names the repository in subjects
+	Repository string
+	// Pusher is mentioned in the summary when set
+	Pusher string
+	Rules  []RecipientRule
+	// MaxDiffLines and MaxDiffLineLength truncate commit diffs, they default
+	// to 1000 lines of 500 characters
+	MaxDiffLines      int
+	MaxDiffLineLength int
+	// MaxCommitEmails caps the commit emails sent per ref update, commits
+	// beyond it are only listed in the summary. Defaults to 100.
+	MaxCommitEmails int
+	// MessageIDDomain is the right hand side of generated Message-IDs
+	MessageIDDomain string
+	Now             func() time.Time
+}
+
+type NotificationEmail struct {
+	From    string
+	To      []string
+	Subject string
+	// Headers holds threading and X-Git-* headers
+	Headers map[string]string
+	Body    string
+}
+
+// Bytes renders the email as an RFC 5322 message.
+func (email NotificationEmail) Bytes() []byte {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "From: %s\r\n", email.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+
+	keys := make([]string, 0, len(email.Headers))
+	for key := range email.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, email.Headers[key])
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(email.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+type EmailSender interface {
+	Send(email NotificationEmail) error
+}
+
+type SMTPSender struct {
+	// Addr is the host:port of the SMTP server
+	Addr string
+	// Auth is optional
+	Auth smtp.Auth
+}
+
+func (sender SMTPSender) Send(email NotificationEmail) error {
+	from, err := mail.ParseAddress(email.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address %s: %w", email.From, err)
+	}
+	recipients := make([]string, 0, len(email.To))
+	for _, to := range email.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient address %s: %w", to, err)
+		}
+		recipients = append(recipients, address.Address)
+	}
+	return smtp.SendMail(sender.Addr, sender.Auth, from.Address, recipients, email.Bytes())
+}
+
+func isNullHash(hash string) bool {
+	return strings.Trim(hash, "0") == ""
+}
+
+func refChangeType(update RefUpdate, fastForward bool) string {
+	switch {
+	case isNullHash(update.OldHash):
+		return RefCreated
+	case isNullHash(update.NewHash):
+		return RefDeleted
+	case fastForward:
+		return RefUpdated
+	default:
+		return RefForceUpdated
+	}
+}
+
+func shortRefName(ref string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(ref, prefix)
+		}
+	}
+	return ref
+}
+
+func recipientsForRef(ref string, rules []RecipientRule) []string {
+	seen := map[string]bool{}
+	var recipients []string
+	for _, rule := range rules {
+		if !matchesAnyGlob(ref, []string{rule.RefPattern}) {
+			continue
+		}
+		for _, recipient := range rule.Recipients {
+			if !seen[recipient] {
+				seen[recipient] = true
+				recipients = append(recipients, recipient)
+			}
+		}
+	}
+	return recipients
+}
+
+// truncateDiff applies the line count and line length limits to a diff.
+func truncateDiff(diff string, maxLines, maxLineLength int) string {
+	lines := strings.Split(strings.TrimRight(diff, "\n"), "\n")
+	sb := strings.Builder{}
+	for idx, line := range lines {
+		if idx == maxLines {
+			fmt.Fprintf(&sb, "[diff truncated after %d lines, %d more lines not shown]\n", maxLines, len(lines)-maxLines)
+			break
+		}
+		if len(line) > maxLineLength {
+			line = line[:maxLineLength] + " [...]"
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+type notificationContext struct {
+	from              string
+	subjectPrefix     string
+	pushHash          string
+	summaryID         string
+	domain            string
+	maxDiffLines      int
+	maxDiffLineLength int
+}
+
+type refChange struct {
+	update     RefUpdate
+	change     string
+	recipients []string
+	added      []string
+	discarded  []string
+}
+
+// revList lists commits for the given revisions, passed on stdin so long
+// exclusion lists do not hit argument limits.
+func (git GitCLIWrapper) revList(revisions []string) ([]string, error) {
+	stdOut, err := git.runGitRawWithInput(strings.Join(revisions, "\n")+"\n", "rev-list", "--reverse", "--stdin")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(stdOut)), nil
+}
+
+// newCommits returns commits a ref update introduced which are not already
+// reachable from refs outside the push, nor reported for an earlier update.
+func (git GitCLIWrapper) newCommits(update RefUpdate, exclude []string, reported map[string]bool) ([]string, error) {
+	revisions := append([]string{update.NewHash}, exclude...)
+	if !isNullHash(update.OldHash) {
+		revisions = append(revisions, "^"+update.OldHash)
+	}
+	commits, err := git.revList(revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []string
+	for _, commit := range commits {
+		if !reported[commit] {
+			reported[commit] = true
+			added = append(added, commit)
+		}
+	}
+	return added, nil
+}
+
+// BuildNotificationEmails turns the ref updates of a push into a summary
+// email and one email per new commit, threaded under the summary. Updates
+// without matching recipient rules are left out, and nothing is returned
+// when no update has recipients.
+func (git GitCLIWrapper) BuildNotificationEmails(updates []RefUpdate, opts NotificationOptions) ([]NotificationEmail, error) {
+	git.logger.Debugf("building notification emails for %d ref updates", len(updates))
+
+	maxDiffLines, maxDiffLineLength, maxCommitEmails := opts.MaxDiffLines, opts.MaxDiffLineLength, opts.MaxCommitEmails
+	if maxDiffLines <= 0 {
+		maxDiffLines = defaultMaxDiffLines
+	}
+	if maxDiffLineLength <= 0 {
+		maxDiffLineLength = defaultMaxDiffLineLength
+	}
+	if maxCommitEmails <= 0 {
+		maxCommitEmails = defaultMaxCommitEmails
+	}
+	domain := opts.MessageIDDomain
+	if domain == "" {
+		domain = defaultMessageIDDomain
+	}
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+
+	updated := map[string]bool{}
+	for _, update := range updates {
+		updated[update.Ref] = true
+	}
+	stdOut, err := git.runGit("for-each-ref", "--format=%(refname)")
+	if err != nil {
+		git.logger.Warn("failed to list refs")
+		return nil, err
+	}
+	var exclude []string
+	for _, ref := range strings.Split(stdOut, "\n") {
+		if ref != "" && !updated[ref] {
+			exclude = append(exclude, "^"+ref)
+		}
+	}
+	for _, update := range updates {
+		if !isNullHash(update.OldHash) {
+			exclude = append(exclude, "^"+update.OldHash)
+		}
+	}
+
+	var changes []refChange
+	var allRecipients []string
+	seenRecipients := map[string]bool{}
+	reported := map[string]bool{}
+	for _, update := range updates {
+		recipients := recipientsForRef(update.Ref, opts.Rules)
+		if len(recipients) == 0 {
+			git.logger.Debugf("no recipients configured for %s", update.Ref)
+			continue
+		}
+		for _, recipient := range recipients {
+			if !seenRecipients[recipient] {
+				seenRecipients[recipient] = true
+				allRecipients = append(allRecipients, recipient)
+			}
+		}
+
+		change := refChange{update: update, recipients: recipients}
+		fastForward := true
+		if !isNullHash(update.OldHash) && !isNullHash(update.NewHash) {
+			if _, err := git.runGit("merge-base", "--is-ancestor", update.OldHash, update.NewHash); err != nil {
+				fastForward = false
+				if change.discarded, err = git.revList([]string{update.OldHash, "^" + update.NewHash}); err != nil {
+					git.logger.Warnf("failed to list discarded commits on %s", update.Ref)
+					return nil, err
+				}
+			}
+		}
+		change.change = refChangeType(update, fastForward)
+		if change.change != RefDeleted {
+			if change.added, err = git.newCommits(update, exclude, reported); err != nil {
+				git.logger.Warnf("failed to list new commits on %s", update.Ref)
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	pushID := sha1.New()
+	fmt.Fprintf(pushID, "%d", now.UnixNano())
+	for _, change := range changes {
+		fmt.Fprintf(pushID, "%s %s %s\n", change.update.Ref, change.update.OldHash, change.update.NewHash)
+	}
+	pushHash := fmt.Sprintf("%x", pushID.Sum(nil))[:16]
+	summaryID := fmt.Sprintf("<push-%s@%s>", pushHash, domain)
+	subjectPrefix := ""
+	if opts.Repository != "" {
+		subjectPrefix = "[" + opts.Repository + "] "
+	}
+
+	context := notificationContext{
+		from:              opts.From,
+		subjectPrefix:     subjectPrefix,
+		pushHash:          pushHash,
+		summaryID:         summaryID,
+		domain:            domain,
+		maxDiffLines:      maxDiffLines,
+		maxDiffLineLength: maxDiffLineLength,
+	}
+
+	summary := NotificationEmail{
+		From:    opts.From,
+		To:      allRecipients,
+		Subject: subjectPrefix + pushSummarySubject(changes),
+		Headers: map[string]string{
+			"Message-ID": summaryID,
+			"Date":       now.Format(time.RFC1123Z),
+		},
+	}
+	if len(changes) == 1 {
+		summary.Headers["X-Git-Refname"] = changes[0].update.Ref
+		summary.Headers["X-Git-Oldrev"] = changes[0].update.OldHash
+		summary.Headers["X-Git-Newrev"] = changes[0].update.NewHash
+	}
+	body := strings.Builder{}
+	if opts.Pusher != "" {
+		fmt.Fprintf(&body, "Pushed by %s.\n\n", opts.Pusher)
+	}
+
+	var commitEmails []NotificationEmail
+	for _, change := range changes {
+		update := change.update
+		fmt.Fprintf(&body, "%s %s", update.Ref, change.change)
+		switch change.change {
+		case RefCreated:
+			fmt.Fprintf(&body, " at %s\n", shortHash(update.NewHash))
+		case RefDeleted:
+			fmt.Fprintf(&body, ", was %s\n", shortHash(update.OldHash))
+		default:
+			fmt.Fprintf(&body, " from %s to %s\n", shortHash(update.OldHash), shortHash(update.NewHash))
+		}
+
+		if len(change.discarded) > 0 {
+			fmt.Fprintf(&body, "\n  Commits no longer on %s:\n", shortRefName(update.Ref))
+			if err := git.writeCommitList(&body, change.discarded); err != nil {
+				return nil, err
+			}
+		}
+		if len(change.added) > 0 {
+			fmt.Fprintf(&body, "\n  New commits:\n")
+			if err := git.writeCommitList(&body, change.added); err != nil {
+				return nil, err
+			}
+			if len(change.added) > maxCommitEmails {
+				fmt.Fprintf(&body, "\n  Only the first %d commits are sent as separate emails.\n", maxCommitEmails)
+			}
+		}
+		body.WriteString("\n")
+
+		for idx, commit := range change.added {
+			if idx == maxCommitEmails {
+				break
+			}
+			email, err := git.commitEmail(commit, change, idx+1, context)
+			if err != nil {
+				return nil, err
+			}
+			commitEmails = append(commitEmails, *email)
+		}
+	}
+	summary.Body = body.String()
+
+	return append([]NotificationEmail{summary}, commitEmails...), nil
+}
+
+func pushSummarySubject(changes []refChange) string {
+	if len(changes) > 1 {
+		return fmt.Sprintf("%d refs updated", len(changes))
+	}
+	change := changes[0]
+	subject := fmt.Sprintf("%s %s", shortRefName(change.update.Ref), change.change)
+	if len(change.added) > 0 {
+		subject += fmt.Sprintf(" (%d new commits)", len(change.added))
+	}
+	return subject
+}
+
+func (git GitCLIWrapper) writeCommitList(body *strings.Builder, commits []string) error {
+	stdOut, err := git.runGitRawWithInput(strings.Join(commits, "\n")+"\n", "log", "--no-walk=unsorted", "--stdin", "--format=    %h %s")
+	if err != nil {
+		git.logger.Warn("failed to describe commits for the summary")
+		return err
+	}
+	body.Write(stdOut)
+	return nil
+}
+
+func (git GitCLIWrapper) commitEmail(commit string, change refChange, number int, context notificationContext) (*NotificationEmail, error) {
+	details, err := git.runGit("log", "-1", "--format=%an <%ae>%x00%aD%x00%s%x00%B", commit)
+	if err != nil {
+		git.logger.Warnf("failed to read commit %s", commit)
+		return nil, err
+	}
+	fields := strings.SplitN(details, "\x00", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("git log returned an unexpected format for commit %s", commit)
+	}
+
+	diff, err := git.runGitRaw("show", "--format=", "--stat", "--patch", "--diff-merges=first-parent", "-M", commit)
+	if err != nil {
+		git.logger.Warnf("failed to read the diff of commit %s", commit)
+		return nil, err
+	}
+
+	body := strings.Builder{}
+	fmt.Fprintf(&body, "commit %s\nAuthor: %s\nDate:   %s\nRef:    %s\n\n", commit, fields[0], fields[1], change.update.Ref)
+	body.WriteString(strings.TrimRight(fields[3], "\n") + "\n\n---\n")
+	body.WriteString(truncateDiff(string(diff), context.maxDiffLines, context.maxDiffLineLength))
+
+	return &NotificationEmail{
+		From:    context.from,
+		To:      change.recipients,
+		Subject: fmt.Sprintf("%s%s %02d/%02d: %s", context.subjectPrefix, shortRefName(change.update.Ref), number, len(change.added), fields[2]),
+		Headers: map[string]string{
+			"Message-ID":    fmt.Sprintf("<%s.%s@%s>", commit, context.pushHash, context.domain),
+			"In-Reply-To":   context.summaryID,
+			"References":    context.summaryID,
+			"Date":          fields[1],
+			"X-Git-Refname": change.update.Ref,
+			"X-Git-Rev":     commit,
+		},
+		Body: body.String(),
+	}, nil
+}
+
+// SendNotificationEmails builds the emails for a push and sends them in
+// order, stopping at the first failure.
+func (git GitCLIWrapper) SendNotificationEmails(updates []RefUpdate, opts NotificationOptions, sender EmailSender) error {
+	emails, err := git.BuildNotificationEmails(updates, opts)
+	if err != nil {
+		return err
+	}
+	for idx, email := range emails {
+		if err := sender.Send(email); err != nil {
+			git.logger.Warnf("failed to send notification %d of %d", idx+1, len(emails))
+			return err
+		}
+	}
+	return nil
+}
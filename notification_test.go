@@ -0,0 +1,139 @@
+package gitcliwrapper
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+type receivedEmail struct {
+	from       string
+	recipients []string
+	message    *mail.Message
+}
+
+// startTestSMTPServer accepts mail on a local port and hands each message
+// to the returned channel, it speaks just enough SMTP for net/smtp.
+func startTestSMTPServer(t *testing.T) (string, <-chan receivedEmail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan receivedEmail, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSMTP(conn, received)
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func serveTestSMTP(conn net.Conn, received chan<- receivedEmail) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost")
+	email := receivedEmail{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			email.from = strings.Trim(strings.TrimSpace(line)[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			email.recipients = append(email.recipients, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 go ahead")
+			data := strings.Builder{}
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			message, err := mail.ReadMessage(strings.NewReader(data.String()))
+			if err != nil {
+				reply("554 " + err.Error())
+				continue
+			}
+			email.message = message
+			received <- email
+			email = receivedEmail{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSendNotificationEmails(t *testing.T) {
+	git, dir := newTestRepository(t)
+	oldHash := runTestGit(t, dir, "rev-parse", "HEAD")
+	runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "first change")
+	runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "second change")
+	newHash := runTestGit(t, dir, "rev-parse", "HEAD")
+
+	addr, received := startTestSMTPServer(t)
+	err := git.SendNotificationEmails([]RefUpdate{{Ref: "refs/heads/main", OldHash: oldHash, NewHash: newHash}}, NotificationOptions{
+		From:       "Git <git@example.com>",
+		Repository: "repo",
+		Rules:      []RecipientRule{{RefPattern: "refs/heads/*", Recipients: []string{"Dev <dev@example.com>"}}},
+	}, SMTPSender{Addr: addr})
+	if err != nil {
+		t.Fatalf("SendNotificationEmails: %s", err)
+	}
+
+	var emails []receivedEmail
+	for len(received) > 0 {
+		emails = append(emails, <-received)
+	}
+	if len(emails) != 3 {
+		t.Fatalf("expected a summary and two commit emails, got %d", len(emails))
+	}
+	for _, email := range emails {
+		if email.from != "git@example.com" || len(email.recipients) != 1 || email.recipients[0] != "dev@example.com" {
+			t.Fatalf("unexpected envelope %s -> %v", email.from, email.recipients)
+		}
+	}
+
+	summaryID := emails[0].message.Header.Get("Message-ID")
+	if summaryID == "" {
+		t.Fatal("expected the summary to have a Message-ID")
+	}
+	for idx, subject := range []string{"first change", "second change"} {
+		header := emails[idx+1].message.Header
+		if header.Get("In-Reply-To") != summaryID {
+			t.Errorf("expected commit email %d to reply to %s, got %s", idx+1, summaryID, header.Get("In-Reply-To"))
+		}
+		if !strings.HasSuffix(header.Get("Subject"), subject) {
+			t.Errorf("expected commit email %d to be about %q, got %q", idx+1, subject, header.Get("Subject"))
+		}
+		if header.Get("X-Git-Refname") != "refs/heads/main" {
+			t.Errorf("expected commit email %d to name the ref, got %q", idx+1, header.Get("X-Git-Refname"))
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package gitcliwrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultOutboxFile = "gitcliwrapper-outbox.json"
+
+const (
+	OutboxPushed      = "pushed"
+	OutboxConflict    = "conflict"
+	OutboxUnreachable = "unreachable"
+	OutboxFailed      = "failed"
+)
+
+var ErrPushQueued = errors.New("remote is unreachable, the push was queued in the outbox")
+
+type PendingPush struct {
+	ID       string            `json:"id"`
+	QueuedAt time.Time         `json:"queuedAt"`
+	Remote   string            `json:"remote"`
+	RefSpecs []string          `json:"refSpecs"`
+	Force    bool              `json:"force,omitempty"`
+	Atomic   bool              `json:"atomic,omitempty"`
+	Leases   map[string]string `json:"leases,omitempty"`
+	// Namespace is the ref namespace the push targets were queued in
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type OutboxResult struct {
+	Push   PendingPush
+	Status string
+	Detail string
+}
+
+// WithOutbox returns a copy of the wrapper where pushes failing with a
+// network error are persisted and can be replayed later with FlushOutbox.
+// The outbox is stored inside the git directory when path is empty.
+func (git GitCLIWrapper) WithOutbox(path string) (*GitCLIWrapper, error) {
+	if path == "" {
+		gitPath, err := git.runGit("rev-parse", "--git-path", defaultOutboxFile)
+		if err != nil {
+			git.logger.Warn("failed to resolve the git directory for the outbox")
+			return nil, err
+		}
+		path = gitPath
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(git.cmd.Dir, path)
+	}
+
+	git.outboxPath = path
+	return &git, nil
+}
+
+func (git GitCLIWrapper) readOutbox() ([]PendingPush, error) {
+	data, err := os.ReadFile(git.outboxPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []PendingPush
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("failed to parse outbox %s: %w", git.outboxPath, err)
+	}
+	return pending, nil
+}
+
+func (git GitCLIWrapper) writeOutbox(pending []PendingPush) error {
+	if len(pending) == 0 {
+		if err := os.Remove(git.outboxPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := git.outboxPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, git.outboxPath)
+}
+
+// remoteTrackingLease returns the last known remote value of a push target,
+// so a replayed force push does not clobber work pushed in the meantime. In
+// a namespace the remote tracking refs are those of the plain branches, so
+// the namespaced ref is looked up on the remote instead.
+func (git GitCLIWrapper) remoteTrackingLease(refSpec string) (string, string, bool) {
+	_, target, found := strings.Cut(strings.TrimPrefix(refSpec, "+"), ":")
+	if !found {
+		target = strings.TrimPrefix(refSpec, "+")
+	}
+
+	if git.namespace != "" {
+		hash, err := git.remoteRefHash(git.remote, target)
+		if err != nil {
+			return "", "", false
+		}
+		return target, hash, true
+	}
+
+	branch := strings.TrimPrefix(target, "refs/heads/")
+	if strings.HasPrefix(branch, "refs/") {
+		return "", "", false
+	}
+
+	hash, err := git.runGit("rev-parse", "--verify", "--quiet", fmt.Sprintf("refs/remotes/%s/%s", git.remote, branch))
+	if err != nil || hash == "" {
+		return "", "", false
+	}
+	return target, hash, true
+}
+
+// pinRefSpec resolves the source of a refspec to the object it points to
+// now, so a replayed push sends what was meant to be pushed rather than
+// whatever the source points to by then.
+func (git GitCLIWrapper) pinRefSpec(refSpec string) (string, error) {
+	force := ""
+	if strings.HasPrefix(refSpec, "+") {
+		force, refSpec = "+", refSpec[1:]
+	}
+	source, target, found := strings.Cut(refSpec, ":")
+	if source == "" {
+		// deletions have nothing to pin
+		return force + refSpec, nil
+	}
+
+	fullName, _ := git.runGit("rev-parse", "--symbolic-full-name", source)
+	if !found {
+		target = fullName
+		if target == "" {
+			target = source
+		}
+	}
+	if !strings.HasPrefix(target, "refs/") {
+		// a hash can only be pushed to a full ref name, which is picked the
+		// way git picks it for a ref source
+		prefix := "refs/heads/"
+		if strings.HasPrefix(fullName, "refs/tags/") {
+			prefix = "refs/tags/"
+		}
+		target = prefix + target
+	}
+
+	hash, err := git.runGit("rev-parse", "--verify", source)
+	if err != nil {
+		git.logger.Warnf("failed to resolve %s to queue the push", source)
+		return "", err
+	}
+	return force + hash + ":" + target, nil
+}
+
+func (git GitCLIWrapper) queuePush(refSpecs []string, opts PushOptions) error {
+	pending, err := git.readOutbox()
+	if err != nil {
+		return err
+	}
+
+	pinned := make([]string, 0, len(refSpecs))
+	for _, refSpec := range refSpecs {
+		pinnedRefSpec, err := git.pinRefSpec(refSpec)
+		if err != nil {
+			return err
+		}
+		pinned = append(pinned, pinnedRefSpec)
+	}
+	refSpecs = pinned
+
+	leases := map[string]string{}
+	for ref, hash := range opts.Leases {
+		leases[ref] = hash
+	}
+	if opts.Force {
+		for _, refSpec := range refSpecs {
+			if refSpecLeased(refSpec, leases) {
+				continue
+			}
+			target, hash, ok := git.remoteTrackingLease(refSpec)
+			if ok {
+				leases[target] = hash
+				continue
+			}
+			if git.namespace != "" {
+				// without a lease the replayed push could overwrite anything
+				// pushed to the namespace in the meantime
+				return fmt.Errorf("can not queue a force push to %s without knowing its value in namespace %s", refSpec, git.namespace)
+			}
+		}
+	}
+
+	pending = append(pending, PendingPush{
+		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+		QueuedAt:  time.Now().UTC(),
+		Remote:    git.remote,
+		RefSpecs:  refSpecs,
+		Force:     opts.Force,
+		Atomic:    opts.Atomic,
+		Leases:    leases,
+		Namespace: git.namespace,
+	})
+
+	return git.writeOutbox(pending)
+}
+
+func (git GitCLIWrapper) PendingPushes() ([]PendingPush, error) {
+	if git.outboxPath == "" {
+		return nil, errors.New("the outbox is not enabled")
+	}
+	return git.readOutbox()
+}
+
+func (git GitCLIWrapper) DiscardPendingPush(id string) error {
+	git.logger.Debugf("discarding pending push %s", id)
+	pending, err := git.PendingPushes()
+	if err != nil {
+		return err
+	}
+
+	for idx, push := range pending {
+		if push.ID == id {
+			return git.writeOutbox(append(pending[:idx], pending[idx+1:]...))
+		}
+	}
+	return fmt.Errorf("no pending push with id %s", id)
+}
+
+// FlushOutbox replays pending pushes in the order they were queued. It stops
+// at the first push which can not be completed, leaving it and everything
+// after it in the outbox. Conflicting pushes need to be resolved and then
+// discarded with DiscardPendingPush.
+func (git GitCLIWrapper) FlushOutbox() ([]OutboxResult, error) {
+	git.logger.Debug("flushing the push outbox")
+	pending, err := git.PendingPushes()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []OutboxResult
+	for len(pending) > 0 {
+		push := pending[0]
+		opts := PushOptions{Force: push.Force, Atomic: push.Atomic, Leases: push.Leases}
+
+		git.namespace = push.Namespace
+		err := git.push(push.Remote, push.RefSpecs, opts)
+		if err != nil {
+			result := OutboxResult{Push: push, Status: OutboxFailed, Detail: err.Error()}
+			var commandErr *CommandError
+			if errors.As(err, &commandErr) {
+				result.Detail = strings.TrimSpace(commandErr.Stderr)
+			}
+			switch {
+			case isNetworkError(err):
+				result.Status = OutboxUnreachable
+				git.logger.Warnf("remote %s is still unreachable", push.Remote)
+			case isPushConflict(err):
+				result.Status = OutboxConflict
+				git.logger.Warnf("pending push %s conflicts with changes on remote %s", push.ID, push.Remote)
+			default:
+				git.logger.Warnf("pending push %s failed", push.ID)
+			}
+			return append(results, result), nil
+		}
+
+		results = append(results, OutboxResult{Push: push, Status: OutboxPushed})
+		pending = pending[1:]
+		if err := git.writeOutbox(pending); err != nil {
+			git.logger.Error("failed to update the outbox after a successful push")
+			return results, err
+		}
+	}
+
+	return results, nil
+}
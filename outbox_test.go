@@ -0,0 +1,106 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPushToMissingRepositoryIsNotQueued(t *testing.T) {
+	git, dir := newTestRepository(t)
+	runTestGit(t, dir, "remote", "add", "origin", filepath.Join(t.TempDir(), "missing.git"))
+	git.remote = "origin"
+	outbox, err := git.WithOutbox("")
+	if err != nil {
+		t.Fatalf("WithOutbox: %s", err)
+	}
+
+	err = outbox.Push([]string{"main"}, PushOptions{})
+	if err == nil || errors.Is(err, ErrPushQueued) {
+		t.Fatalf("expected a hard failure, got %v", err)
+	}
+	pending, err := outbox.PendingPushes()
+	if err != nil {
+		t.Fatalf("PendingPushes: %s", err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("expected nothing queued, got %v", pending)
+	}
+}
+
+func TestQueuedPushIsPinned(t *testing.T) {
+	git, dir := newTestRepository(t)
+	runTestGit(t, dir, "remote", "add", "origin", "https://unreachable.invalid/repo.git")
+	git.remote = "origin"
+	outbox, err := git.WithOutbox("")
+	if err != nil {
+		t.Fatalf("WithOutbox: %s", err)
+	}
+
+	queued := runTestGit(t, dir, "rev-parse", "HEAD")
+	if err := outbox.ForcePushSourceToTargetRef("HEAD", "main"); !errors.Is(err, ErrPushQueued) {
+		t.Fatalf("expected the push to be queued, got %v", err)
+	}
+	pending, err := outbox.PendingPushes()
+	if err != nil {
+		t.Fatalf("PendingPushes: %s", err)
+	}
+	if len(pending) != 1 || len(pending[0].RefSpecs) != 1 {
+		t.Fatalf("expected one queued push, got %v", pending)
+	}
+	if refSpec := pending[0].RefSpecs[0]; refSpec != queued+":refs/heads/main" {
+		t.Fatalf("expected the source to be pinned to %s, got %s", queued, refSpec)
+	}
+
+	remoteDir := t.TempDir()
+	runTestGit(t, remoteDir, "init", "--quiet", "--bare")
+	runTestGit(t, dir, "remote", "set-url", "origin", remoteDir)
+	runTestGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", "after queueing")
+
+	results, err := outbox.FlushOutbox()
+	if err != nil {
+		t.Fatalf("FlushOutbox: %s", err)
+	}
+	if len(results) != 1 || results[0].Status != OutboxPushed {
+		t.Fatalf("expected the push to be replayed, got %v", results)
+	}
+	if pushed := runTestGit(t, remoteDir, "rev-parse", "refs/heads/main"); pushed != queued {
+		t.Fatalf("expected %s on the remote, got %s", queued, pushed)
+	}
+}
+
+func TestFlushOutboxSurfacesRemoteMoves(t *testing.T) {
+	remoteDir, otherDir := newTestRemote(t)
+	git, dir := newTestRepository(t)
+	runTestGit(t, dir, "remote", "add", "origin", remoteDir)
+	runTestGit(t, dir, "fetch", "--quiet", "origin")
+	runTestGit(t, dir, "reset", "--quiet", "--hard", "origin/main")
+	git.remote = "origin"
+	outbox, err := git.WithOutbox("")
+	if err != nil {
+		t.Fatalf("WithOutbox: %s", err)
+	}
+
+	runTestGit(t, dir, "remote", "set-url", "origin", "https://unreachable.invalid/repo.git")
+	runTestGit(t, dir, "commit", "--quiet", "--amend", "--allow-empty", "-m", "rewritten")
+	if err := outbox.ForcePushSourceToTargetRef("HEAD", "main"); !errors.Is(err, ErrPushQueued) {
+		t.Fatalf("expected the push to be queued, got %v", err)
+	}
+
+	// someone else pushes while the force push waits in the outbox
+	runTestGit(t, otherDir, "commit", "--quiet", "--allow-empty", "-m", "pushed meanwhile")
+	runTestGit(t, otherDir, "push", "--quiet", remoteDir, "main")
+	moved := runTestGit(t, otherDir, "rev-parse", "HEAD")
+
+	runTestGit(t, dir, "remote", "set-url", "origin", remoteDir)
+	results, err := outbox.FlushOutbox()
+	if err != nil {
+		t.Fatalf("FlushOutbox: %s", err)
+	}
+	if len(results) != 1 || results[0].Status != OutboxConflict {
+		t.Fatalf("expected a conflict, got %v", results)
+	}
+	if hash := runTestGit(t, remoteDir, "rev-parse", "refs/heads/main"); hash != moved {
+		t.Fatalf("expected the remote to keep %s, got %s", moved, hash)
+	}
+}
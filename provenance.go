@@ -0,0 +1,127 @@
+package gitcliwrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	inTotoStatementType     = "https://in-toto.io/Statement/v1"
+	sourceProvenancePredURI = "https://github.com/marmotherder/go-gitcliwrapper/source-provenance/v1"
+)
+
+const (
+	TagSignatureNotApplicable = "not_applicable"
+	TagSignatureUnsigned      = "unsigned"
+	TagSignatureVerified      = "verified"
+	TagSignatureFailed        = "failed"
+)
+
+type ProvenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []ProvenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     SourceProvenance    `json:"predicate"`
+}
+
+type ProvenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type SourceProvenance struct {
+	Repository   string    `json:"repository,omitempty"`
+	Ref          string    `json:"ref,omitempty"`
+	Commit       string    `json:"commit"`
+	Tree         string    `json:"tree"`
+	CommitTime   time.Time `json:"commitTime"`
+	TagSignature string    `json:"tagSignature"`
+	Dirty        bool      `json:"dirty"`
+}
+
+func (git GitCLIWrapper) SourceProvenance(ref string) ([]byte, error) {
+	git.logger.Debugf("generating source provenance for %s", ref)
+
+	commitAndTree, err := git.runGit("log", "-n", "1", "--format=%H %T %cI", ref+"^{commit}")
+	if err != nil {
+		git.logger.Warnf("failed to resolve %s for provenance", ref)
+		return nil, err
+	}
+	fields := strings.Fields(commitAndTree)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("git log returned an unexpected format for %s: %q", ref, commitAndTree)
+	}
+	commitTime, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		git.logger.Warnf("commit time for %s came back in an unexpected format", ref)
+		return nil, err
+	}
+
+	provenance := SourceProvenance{
+		Commit:       fields[0],
+		Tree:         fields[1],
+		CommitTime:   commitTime,
+		TagSignature: git.tagSignatureStatus(ref),
+	}
+
+	if fullName, err := git.runGit("rev-parse", "--symbolic-full-name", ref); err == nil {
+		provenance.Ref = fullName
+	}
+
+	if git.remote != "" {
+		if remoteURL, err := git.runGit("remote", "get-url", git.remote); err == nil {
+			provenance.Repository = stripCredentials(remoteURL)
+		} else {
+			git.logger.Warnf("failed to get the url for remote %s", git.remote)
+		}
+	}
+
+	if bare, err := git.runGit("rev-parse", "--is-bare-repository"); err == nil && bare == "false" {
+		status, err := git.runGit("status", "--porcelain", "--untracked-files=no")
+		if err != nil {
+			git.logger.Warn("failed to get the working tree status")
+			return nil, err
+		}
+		provenance.Dirty = status != ""
+	}
+
+	subjectName := provenance.Repository
+	if subjectName == "" {
+		subjectName = ref
+	}
+	statement := ProvenanceStatement{
+		Type: inTotoStatementType,
+		Subject: []ProvenanceSubject{{
+			Name: subjectName,
+			Digest: map[string]string{
+				"gitCommit": provenance.Commit,
+				"gitTree":   provenance.Tree,
+			},
+		}},
+		PredicateType: sourceProvenancePredURI,
+		Predicate:     provenance,
+	}
+
+	return json.MarshalIndent(statement, "", "  ")
+}
+
+func (git GitCLIWrapper) tagSignatureStatus(ref string) string {
+	objectType, err := git.runGit("cat-file", "-t", ref)
+	if err != nil || objectType != "tag" {
+		return TagSignatureNotApplicable
+	}
+
+	tagObject, err := git.runGit("cat-file", "tag", ref)
+	if err != nil || !strings.Contains(tagObject, "-----BEGIN ") {
+		return TagSignatureUnsigned
+	}
+
+	if _, err := git.runGit("verify-tag", ref); err != nil {
+		git.logger.Warnf("signature verification failed for tag %s", ref)
+		return TagSignatureFailed
+	}
+
+	return TagSignatureVerified
+}
@@ -0,0 +1,157 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	pullRequestRefPrefix  = "refs/pull/"
+	mergeRequestRefPrefix = "refs/merge-requests/"
+)
+
+type FetchPullRequestsOptions struct {
+	// Namespace is the local ref namespace the pull request refs are fetched
+	// into, defaults to refs/pull-requests/<remote>
+	Namespace string
+	// Numbers limits the fetch to the given pull request numbers, all pull
+	// requests are fetched when empty
+	Numbers []int
+	// Prune removes local pull request refs which no longer exist on the remote
+	Prune bool
+}
+
+type PullRequestRef struct {
+	Number int
+	// Kind is either pull (GitHub style) or merge-requests (GitLab style)
+	Kind      string
+	HeadHash  string
+	MergeHash string
+}
+
+func (git GitCLIWrapper) pullRequestNamespace(opts FetchPullRequestsOptions) string {
+	if opts.Namespace != "" {
+		return strings.TrimSuffix(opts.Namespace, "/")
+	}
+	return "refs/pull-requests/" + git.remote
+}
+
+func (git GitCLIWrapper) FetchPullRequests(opts FetchPullRequestsOptions) error {
+	namespace := git.pullRequestNamespace(opts)
+	git.logger.Debugf("fetching pull request refs from remote %s into %s", git.remote, namespace)
+
+	var refSpecs []string
+	if len(opts.Numbers) == 0 {
+		refSpecs = []string{
+			fmt.Sprintf("+%s*/head:%s/pull/*/head", pullRequestRefPrefix, namespace),
+			fmt.Sprintf("+%s*/merge:%s/pull/*/merge", pullRequestRefPrefix, namespace),
+			fmt.Sprintf("+%s*/head:%s/merge-requests/*/head", mergeRequestRefPrefix, namespace),
+		}
+	} else {
+		remoteRefs, err := git.ListPullRequestRefs()
+		if err != nil {
+			return err
+		}
+		wanted := map[int]bool{}
+		for _, number := range opts.Numbers {
+			wanted[number] = true
+		}
+		for _, remoteRef := range remoteRefs {
+			if !wanted[remoteRef.Number] {
+				continue
+			}
+			prefix := fmt.Sprintf("refs/%s/%d/", remoteRef.Kind, remoteRef.Number)
+			localPrefix := fmt.Sprintf("%s/%s/%d/", namespace, remoteRef.Kind, remoteRef.Number)
+			if remoteRef.HeadHash != "" {
+				refSpecs = append(refSpecs, fmt.Sprintf("+%shead:%shead", prefix, localPrefix))
+			}
+			if remoteRef.MergeHash != "" {
+				refSpecs = append(refSpecs, fmt.Sprintf("+%smerge:%smerge", prefix, localPrefix))
+			}
+		}
+		if len(refSpecs) == 0 {
+			return fmt.Errorf("none of the requested pull requests were found on remote %s", git.remote)
+		}
+	}
+
+	args := []string{"fetch"}
+	if opts.Prune {
+		args = append(args, "--prune")
+	}
+	args = append(args, git.remote)
+	args = append(args, refSpecs...)
+
+	if _, err := git.runGit(args...); err != nil {
+		git.logger.Warnf("failed to fetch pull request refs from remote %s", git.remote)
+		return err
+	}
+
+	return nil
+}
+
+func (git GitCLIWrapper) ListPullRequestRefs() ([]PullRequestRef, error) {
+	git.logger.Debugf("listing pull request refs on remote %s", git.remote)
+	stdOut, err := git.runGit("ls-remote", git.remote, pullRequestRefPrefix+"*", mergeRequestRefPrefix+"*")
+	if err != nil {
+		git.logger.Warn("failed to lookup pull request refs from remote")
+		return nil, err
+	}
+
+	byKey := map[string]*PullRequestRef{}
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		hash, ref := fields[0], fields[1]
+
+		var kind, rest string
+		switch {
+		case strings.HasPrefix(ref, pullRequestRefPrefix):
+			kind, rest = "pull", strings.TrimPrefix(ref, pullRequestRefPrefix)
+		case strings.HasPrefix(ref, mergeRequestRefPrefix):
+			kind, rest = "merge-requests", strings.TrimPrefix(ref, mergeRequestRefPrefix)
+		default:
+			continue
+		}
+
+		parts := strings.Split(rest, "/")
+		if len(parts) != 2 {
+			git.logger.Debugf("skipping pull request ref of unexpected format: %s", ref)
+			continue
+		}
+		number, err := strconv.Atoi(parts[0])
+		if err != nil {
+			git.logger.Debugf("skipping pull request ref with a non numeric id: %s", ref)
+			continue
+		}
+
+		key := kind + "/" + parts[0]
+		pr, ok := byKey[key]
+		if !ok {
+			pr = &PullRequestRef{Number: number, Kind: kind}
+			byKey[key] = pr
+		}
+		switch parts[1] {
+		case "head":
+			pr.HeadHash = hash
+		case "merge":
+			pr.MergeHash = hash
+		}
+	}
+
+	pullRequests := make([]PullRequestRef, 0, len(byKey))
+	for _, pr := range byKey {
+		pullRequests = append(pullRequests, *pr)
+	}
+	sort.Slice(pullRequests, func(i, j int) bool {
+		if pullRequests[i].Kind != pullRequests[j].Kind {
+			return pullRequests[i].Kind > pullRequests[j].Kind
+		}
+		return pullRequests[i].Number < pullRequests[j].Number
+	})
+
+	return pullRequests, nil
+}
@@ -0,0 +1,167 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type PushOptions struct {
+	Force bool
+	// Leases maps a remote ref to the hash it is expected to be at before
+	// the push, an empty hash expects the ref to not exist yet
+	Leases map[string]string
+	// Atomic asks the remote to update either all refs or none of them
+	Atomic bool
+}
+
+// networkErrorMarkers are transport failures which may go away, a push
+// failing with one of them is worth retrying.
+var networkErrorMarkers = []string{
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"connection refused",
+	"connection timed out",
+	"connection reset",
+	"operation timed out",
+	"network is unreachable",
+	"no route to host",
+	"failed to connect",
+	"the remote end hung up unexpectedly",
+	"early eof",
+}
+
+// hardFailureMarkers are failures which retrying can not fix, such as
+// rejected credentials or a missing repository. They win over the network
+// markers as git often reports both.
+var hardFailureMarkers = []string{
+	"permission denied",
+	"authentication failed",
+	"could not read username",
+	"could not read password",
+	"does not appear to be a git repository",
+	"not found",
+	"the requested url returned error: 4",
+}
+
+var pushConflictMarkers = []string{
+	"[rejected]",
+	"stale info",
+	"non-fast-forward",
+	"fetch first",
+}
+
+func stderrContainsAny(err error, markers []string) bool {
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) {
+		return false
+	}
+	stdErr := strings.ToLower(commandErr.Stderr)
+	for _, marker := range markers {
+		if strings.Contains(stdErr, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNetworkError(err error) bool {
+	return stderrContainsAny(err, networkErrorMarkers) && !stderrContainsAny(err, hardFailureMarkers)
+}
+
+func isPushConflict(err error) bool {
+	return stderrContainsAny(err, pushConflictMarkers)
+}
+
+// refSpecLeased tells if the destination of a refspec is covered by a lease,
+// short destinations are matched against branches and tags.
+func refSpecLeased(refSpec string, leases map[string]string) bool {
+	src, dst, found := strings.Cut(strings.TrimPrefix(refSpec, "+"), ":")
+	if !found {
+		dst = src
+	}
+	for _, ref := range []string{dst, "refs/heads/" + dst, "refs/tags/" + dst} {
+		if _, ok := leases[ref]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pushArgs builds a push command. --force would make git ignore every lease,
+// so with leases only the refspecs without one are forced, with a +.
+func pushArgs(remote string, refSpecs []string, opts PushOptions) []string {
+	args := []string{"push"}
+	if opts.Force && len(opts.Leases) == 0 {
+		args = append(args, "--force")
+	}
+	if opts.Force && len(opts.Leases) > 0 {
+		forced := make([]string, 0, len(refSpecs))
+		for _, refSpec := range refSpecs {
+			if !strings.HasPrefix(refSpec, "+") && !refSpecLeased(refSpec, opts.Leases) {
+				refSpec = "+" + refSpec
+			}
+			forced = append(forced, refSpec)
+		}
+		refSpecs = forced
+	}
+	if opts.Atomic {
+		args = append(args, "--atomic")
+	}
+
+	refs := make([]string, 0, len(opts.Leases))
+	for ref := range opts.Leases {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		args = append(args, fmt.Sprintf("--force-with-lease=%s:%s", ref, opts.Leases[ref]))
+	}
+
+	args = append(args, remote)
+	return append(args, refSpecs...)
+}
+
+func (git GitCLIWrapper) push(remote string, refSpecs []string, opts PushOptions) error {
+	refSpecs, opts = git.namespacePush(refSpecs, opts)
+	_, err := git.runGit(pushArgs(remote, refSpecs, opts)...)
+	return err
+}
+
+func (git GitCLIWrapper) Push(refSpecs []string, opts PushOptions) error {
+	git.logger.Debugf("pushing %s to remote %s", refSpecs, git.remote)
+	err := git.push(git.remote, refSpecs, opts)
+	if err == nil {
+		return nil
+	}
+
+	if git.outboxPath != "" && isNetworkError(err) {
+		git.logger.Warnf("remote %s is unreachable, queueing the push in the outbox", git.remote)
+		if queueErr := git.queuePush(refSpecs, opts); queueErr != nil {
+			git.logger.Error("failed to queue the push in the outbox")
+			return queueErr
+		}
+		return ErrPushQueued
+	}
+
+	git.logger.Warnf("failed to push %s to remote %s", refSpecs, git.remote)
+	return err
+}
+
+// remoteRefHash returns the hash a ref points to on the remote, or an empty
+// string when the ref does not exist there.
+func (git GitCLIWrapper) remoteRefHash(remote, ref string) (string, error) {
+	ref = git.namespacedRef(ref)
+	stdOut, err := git.runGit("ls-remote", remote, ref)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	return "", nil
+}
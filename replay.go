@@ -0,0 +1,258 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// git replay was added in 2.44
+const (
+	replayMinMajor = 2
+	replayMinMinor = 44
+)
+
+type ReplayedCommit struct {
+	Old string
+	// New is empty when the commit became empty on the new base and was dropped
+	New string
+}
+
+type ReplayResult struct {
+	// Ref is the branch which was moved, empty when the range ends in a hash
+	Ref      string
+	Previous string
+	Head     string
+	Commits  []ReplayedCommit
+	// UsedReplay is false when the worktree fallback was used
+	UsedReplay bool
+}
+
+func (result ReplayResult) Mapping() map[string]string {
+	mapping := make(map[string]string, len(result.Commits))
+	for _, commit := range result.Commits {
+		mapping[commit.Old] = commit.New
+	}
+	return mapping
+}
+
+// gitVersion returns the major and minor version of the git binary.
+func (git GitCLIWrapper) gitVersion() (int, int, error) {
+	stdOut, err := git.runGit("version")
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(stdOut)
+	if len(fields) < 3 {
+		return 0, 0, fmt.Errorf("unexpected git version output %q", stdOut)
+	}
+	parts := strings.SplitN(fields[2], ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unexpected git version %q", fields[2])
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return major, minor, nil
+}
+
+func (git GitCLIWrapper) supportsReplay() bool {
+	major, minor, err := git.gitVersion()
+	if err != nil {
+		git.logger.Debugf("could not determine the git version, assuming git replay is unavailable: %s", err)
+		return false
+	}
+	return major > replayMinMajor || (major == replayMinMajor && minor >= replayMinMinor)
+}
+
+// ReplayOnto rebases the commits of a range such as main..feature onto
+// newBase without a checkout, and moves the branch at the end of the range
+// when there is one. git replay is used when available, otherwise the
+// commits are cherry-picked in a temporary worktree. Commits which become
+// empty are dropped, merges in the range are refused.
+func (git GitCLIWrapper) ReplayOnto(commitRange, newBase string) (*ReplayResult, error) {
+	git.logger.Debugf("replaying %s onto %s", commitRange, newBase)
+
+	_, tip, found := strings.Cut(commitRange, "..")
+	if !found || tip == "" || strings.HasPrefix(tip, ".") {
+		return nil, fmt.Errorf("%s is not a range of the form base..tip", commitRange)
+	}
+
+	merges, err := git.runGit("rev-list", "--merges", commitRange)
+	if err != nil {
+		git.logger.Warnf("failed to list commits in %s", commitRange)
+		return nil, err
+	}
+	if merges != "" {
+		return nil, fmt.Errorf("range %s contains merge commits which can not be replayed", commitRange)
+	}
+
+	stdOut, err := git.runGit("rev-list", "--reverse", commitRange)
+	if err != nil {
+		git.logger.Warnf("failed to list commits in %s", commitRange)
+		return nil, err
+	}
+	commits := strings.Fields(stdOut)
+
+	result := &ReplayResult{}
+	if fullName, err := git.runGit("rev-parse", "--symbolic-full-name", tip); err == nil && strings.HasPrefix(fullName, "refs/heads/") {
+		result.Ref = fullName
+		if current, err := git.runGit("symbolic-ref", "--quiet", "HEAD"); err == nil && current == fullName {
+			if bare, _ := git.runGit("rev-parse", "--is-bare-repository"); bare != "true" {
+				return nil, fmt.Errorf("branch %s is checked out and can not be replayed without a worktree", tip)
+			}
+		}
+	}
+	if result.Previous, err = git.runGit("rev-parse", "--verify", tip+"^{commit}"); err != nil {
+		git.logger.Warnf("failed to resolve %s", tip)
+		return nil, err
+	}
+	base, err := git.runGit("rev-parse", "--verify", newBase+"^{commit}")
+	if err != nil {
+		git.logger.Warnf("failed to resolve %s", newBase)
+		return nil, err
+	}
+
+	if result.Ref != "" && git.supportsReplay() {
+		err = git.replayWithReplay(commitRange, base, commits, result)
+	} else {
+		err = git.replayInWorktree(base, commits, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Ref != "" {
+		if _, err := git.runGit("update-ref", "-m", "replay onto "+newBase, result.Ref, result.Head, result.Previous); err != nil {
+			git.logger.Warnf("failed to move %s to the replayed commits", result.Ref)
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (git GitCLIWrapper) replayWithReplay(commitRange, base string, commits []string, result *ReplayResult) error {
+	stdOut, err := git.runGit("replay", "--onto", base, commitRange)
+	if err != nil {
+		git.logger.Warnf("git replay of %s failed", commitRange)
+		return err
+	}
+
+	// the output is update-ref --stdin input, only the ref of the range
+	// end is of interest
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 4 && fields[0] == "update" && fields[1] == result.Ref {
+			result.Head = fields[2]
+		}
+	}
+	if result.Head == "" {
+		return fmt.Errorf("git replay did not report an update for %s", result.Ref)
+	}
+	result.UsedReplay = true
+
+	replayed, err := git.runGit("rev-list", "--reverse", base+".."+result.Head)
+	if err != nil {
+		return err
+	}
+	mapping, err := git.matchReplayedCommits(commits, strings.Fields(replayed))
+	if err != nil {
+		return err
+	}
+	result.Commits = mapping
+	return nil
+}
+
+// matchReplayedCommits pairs original and replayed commits by author, author
+// date and subject, which replaying keeps. Commits without a match were
+// dropped.
+func (git GitCLIWrapper) matchReplayedCommits(original, replayed []string) ([]ReplayedCommit, error) {
+	describe := func(commits []string) ([]string, error) {
+		if len(commits) == 0 {
+			return nil, nil
+		}
+		stdOut, err := git.runGitRawWithInput(strings.Join(commits, "\n")+"\n", "log", "--no-walk=unsorted", "--stdin", "--format=%an%x00%ae%x00%at%x00%s")
+		if err != nil {
+			return nil, err
+		}
+		return strings.Split(strings.TrimRight(string(stdOut), "\n"), "\n"), nil
+	}
+	originalKeys, err := describe(original)
+	if err != nil {
+		return nil, err
+	}
+	replayedKeys, err := describe(replayed)
+	if err != nil {
+		return nil, err
+	}
+
+	available := map[string][]string{}
+	for idx, key := range replayedKeys {
+		available[key] = append(available[key], replayed[idx])
+	}
+	mapping := make([]ReplayedCommit, 0, len(original))
+	for idx, key := range originalKeys {
+		commit := ReplayedCommit{Old: original[idx]}
+		if candidates := available[key]; len(candidates) > 0 {
+			commit.New = candidates[0]
+			available[key] = candidates[1:]
+		}
+		mapping = append(mapping, commit)
+	}
+	return mapping, nil
+}
+
+func (git GitCLIWrapper) replayInWorktree(base string, commits []string, result *ReplayResult) error {
+	tmpDir, err := os.MkdirTemp("", "gitcliwrapper-replay-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := git.runGit("worktree", "add", "--quiet", "--detach", tmpDir, base); err != nil {
+		git.logger.Warn("failed to create a temporary worktree to replay in")
+		return err
+	}
+	defer func() {
+		if _, err := git.runGit("worktree", "remove", "--force", tmpDir); err != nil {
+			git.logger.Warnf("failed to remove temporary worktree %s", tmpDir)
+		}
+	}()
+
+	worktree := git
+	worktree.cmd.Dir = tmpDir
+	for _, commit := range commits {
+		replayed := ReplayedCommit{Old: commit}
+		if _, err := worktree.runGit("cherry-pick", "--allow-empty-message", commit); err != nil {
+			unmerged, _ := worktree.runGit("diff", "--name-only", "--diff-filter=U")
+			if unmerged != "" {
+				_, _ = worktree.runGit("cherry-pick", "--abort")
+				return fmt.Errorf("replaying %s conflicts in %s: %w", shortHash(commit), strings.Join(strings.Fields(unmerged), ", "), err)
+			}
+			if _, staged := worktree.runGit("diff", "--cached", "--quiet"); staged != nil {
+				_, _ = worktree.runGit("cherry-pick", "--abort")
+				return err
+			}
+			git.logger.Debugf("commit %s became empty and is dropped", shortHash(commit))
+			if _, err := worktree.runGit("cherry-pick", "--skip"); err != nil {
+				return err
+			}
+			result.Commits = append(result.Commits, replayed)
+			continue
+		}
+
+		if replayed.New, err = worktree.runGit("rev-parse", "HEAD"); err != nil {
+			return err
+		}
+		result.Commits = append(result.Commits, replayed)
+	}
+
+	result.Head, err = worktree.runGit("rev-parse", "HEAD")
+	return err
+}
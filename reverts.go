@@ -0,0 +1,113 @@
+package gitcliwrapper
+
+import (
+	"regexp"
+	"strings"
+)
+
+var revertMessagePattern = regexp.MustCompile(`This reverts commit ([0-9a-fA-F]{7,64})`)
+
+type RevertPair struct {
+	Revert   string
+	Reverted string
+	// InRange is false when the reverted commit is outside the range
+	InRange bool
+}
+
+type RevertResolution struct {
+	// Effective are the commits whose changes remain once reverts are
+	// accounted for, newest first like ListCommits
+	Effective []string
+	// Cancelled are commits in the range which have no net effect, such as
+	// a feature and its revert
+	Cancelled []string
+	Pairs     []RevertPair
+}
+
+// ResolveReverts pairs reverts in a range with the commits they revert and
+// works out the net effective commits. A revert of a revert restores the
+// original commit, and a revert of a commit from before the range stays
+// effective as it changes what was released.
+func (git GitCLIWrapper) ResolveReverts(commitRange ...string) (*RevertResolution, error) {
+	git.logger.Debugf("resolving reverts in %s", commitRange)
+
+	stdOut, err := git.runGit(append([]string{"log", "--format=%H%x00%B%x1e"}, commitRange...)...)
+	if err != nil {
+		git.logger.Warn("failed to run git log")
+		return nil, err
+	}
+
+	var commits []string
+	inRange := map[string]bool{}
+	messages := map[string]string{}
+	for _, record := range strings.Split(stdOut, "\x1e") {
+		hash, message, found := strings.Cut(strings.TrimSpace(record), "\x00")
+		if !found {
+			continue
+		}
+		commits = append(commits, hash)
+		inRange[hash] = true
+		messages[hash] = message
+	}
+
+	resolution := &RevertResolution{}
+	reverts := map[string][]string{}
+	revertedBy := map[string][]string{}
+	resolved := map[string]string{}
+	for _, hash := range commits {
+		for _, match := range revertMessagePattern.FindAllStringSubmatch(messages[hash], -1) {
+			target, ok := resolved[match[1]]
+			if !ok {
+				target = strings.ToLower(match[1])
+				if full, err := git.runGit("rev-parse", "--verify", "--quiet", match[1]+"^{commit}"); err == nil && full != "" {
+					target = full
+				} else {
+					git.logger.Debugf("commit %s reverted by %s could not be resolved", match[1], hash)
+				}
+				resolved[match[1]] = target
+			}
+
+			reverts[hash] = append(reverts[hash], target)
+			revertedBy[target] = append(revertedBy[target], hash)
+			resolution.Pairs = append(resolution.Pairs, RevertPair{Revert: hash, Reverted: target, InRange: inRange[target]})
+		}
+	}
+
+	// a commit is active unless an active revert in the range reverts it
+	active := map[string]bool{}
+	var isActive func(string) bool
+	isActive = func(hash string) bool {
+		if value, ok := active[hash]; ok {
+			return value
+		}
+		active[hash] = true
+		for _, revert := range revertedBy[hash] {
+			if inRange[revert] && isActive(revert) {
+				active[hash] = false
+				break
+			}
+		}
+		return active[hash]
+	}
+
+	for _, hash := range commits {
+		effective := isActive(hash)
+		if targets, ok := reverts[hash]; ok && effective {
+			// a revert only has a net effect on commits outside the range,
+			// reverting one inside cancels both of them out
+			effective = false
+			for _, target := range targets {
+				if !inRange[target] {
+					effective = true
+				}
+			}
+		}
+		if effective {
+			resolution.Effective = append(resolution.Effective, hash)
+		} else {
+			resolution.Cancelled = append(resolution.Cancelled, hash)
+		}
+	}
+
+	return resolution, nil
+}
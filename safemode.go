@@ -0,0 +1,213 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var defaultSafeModeProtocols = []string{"https", "ssh"}
+
+// safeModeOverrides neutralise config which can make git execute commands,
+// they are passed with -c so they win over anything in the repository.
+var safeModeOverrides = []string{
+	"core.hooksPath=" + os.DevNull,
+	"core.fsmonitor=false",
+	"core.sshCommand=ssh",
+	"core.gitProxy=",
+	"core.askPass=",
+	"core.pager=cat",
+	"core.editor=:",
+	"core.alternateRefsCommand=",
+	"sequence.editor=:",
+	"credential.helper=",
+	"gpg.ssh.defaultKeyCommand=",
+	"gc.auto=0",
+	"maintenance.auto=false",
+	"protocol.allow=never",
+}
+
+// riskyConfigPatterns are matched against lower cased config keys, a *
+// stands in for a subsection such as a filter or remote name.
+var riskyConfigPatterns = []string{
+	"core.fsmonitor",
+	"core.sshcommand",
+	"core.hookspath",
+	"core.gitproxy",
+	"core.askpass",
+	"core.pager",
+	"core.editor",
+	"core.alternaterefscommand",
+	"core.worktree",
+	"sequence.editor",
+	"diff.external",
+	"diff.*.textconv",
+	"diff.*.command",
+	"merge.*.driver",
+	"filter.*.clean",
+	"filter.*.smudge",
+	"filter.*.process",
+	"credential.helper",
+	"credential.*.helper",
+	"gpg.program",
+	"gpg.*.program",
+	"gpg.*.defaultkeycommand",
+	"include.path",
+	"includeif.*.path",
+	"submodule.*.update",
+	"remote.*.uploadpack",
+	"remote.*.receivepack",
+	"remote.*.vcs",
+	"uploadpack.packobjectshook",
+	"protocol.allow",
+	"protocol.*.allow",
+	"url.*.insteadof",
+	"url.*.pushinsteadof",
+}
+
+// riskyConfigValuePrefixes limit patterns to values starting with a prefix,
+// a submodule update mode only runs a command when it starts with !
+var riskyConfigValuePrefixes = map[string]string{
+	"submodule.*.update": "!",
+}
+
+type SafeModeOptions struct {
+	// AllowedProtocols are the transports git may use, defaults to https
+	// and ssh. The ext transport is never allowed.
+	AllowedProtocols []string
+}
+
+type UnsafeRepositoryError struct {
+	Keys []string
+}
+
+func (e *UnsafeRepositoryError) Error() string {
+	return fmt.Sprintf("repository config sets risky keys: %s", strings.Join(e.Keys, ", "))
+}
+
+type safeModeConfig struct {
+	configArgs []string
+	env        []string
+}
+
+// WithSafeMode returns a copy of the wrapper for operating on untrusted
+// repositories. Hooks and command executing config are disabled, system and
+// global config is ignored, transports are restricted, and repositories
+// whose own config sets risky keys are rejected with UnsafeRepositoryError.
+func (git GitCLIWrapper) WithSafeMode(opts SafeModeOptions) (*GitCLIWrapper, error) {
+	protocols := opts.AllowedProtocols
+	if len(protocols) == 0 {
+		protocols = defaultSafeModeProtocols
+	}
+
+	config := &safeModeConfig{
+		env: []string{
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_CONFIG_GLOBAL=" + os.DevNull,
+			"GIT_TERMINAL_PROMPT=0",
+			"GIT_PROTOCOL_FROM_USER=0",
+		},
+	}
+	for _, override := range safeModeOverrides {
+		config.configArgs = append(config.configArgs, "-c", override)
+	}
+	for _, protocol := range protocols {
+		if strings.EqualFold(protocol, "ext") {
+			return nil, fmt.Errorf("the ext protocol can not be allowed in safe mode")
+		}
+		config.configArgs = append(config.configArgs, "-c", fmt.Sprintf("protocol.%s.allow=always", protocol))
+	}
+
+	git.safeMode = config
+
+	// submodule update modes are per submodule, so each one configured is
+	// pinned to a plain checkout
+	stdOut, err := git.runGit("config", "--null", "--name-only", "--get-regexp", `^submodule\..*\.update$`)
+	var commandErr *CommandError
+	if err != nil && !(errors.As(err, &commandErr) && commandErr.ExitCode == 1) {
+		git.logger.Warn("failed to read the submodule update modes")
+		return nil, err
+	}
+	for _, key := range strings.Split(stdOut, "\x00") {
+		if key != "" {
+			config.configArgs = append(config.configArgs, "-c", key+"=checkout")
+		}
+	}
+
+	riskyKeys, err := git.FindRiskyConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(riskyKeys) > 0 {
+		git.logger.Warnf("refusing to operate on repository with risky config keys %s", riskyKeys)
+		return nil, &UnsafeRepositoryError{Keys: riskyKeys}
+	}
+
+	return &git, nil
+}
+
+// FindRiskyConfig lists keys in the repository's own config which could make
+// git execute commands or reach unexpected transports.
+func (git GitCLIWrapper) FindRiskyConfig() ([]string, error) {
+	git.logger.Debug("checking repository config for risky keys")
+
+	found := map[string]bool{}
+	for _, scope := range []string{"--local", "--worktree"} {
+		stdOut, err := git.runGit("config", scope, "--no-includes", "--null", "--list")
+		if err != nil {
+			// the worktree scope errors when worktreeConfig is not enabled
+			if scope == "--worktree" {
+				continue
+			}
+			git.logger.Warn("failed to read the repository config")
+			return nil, err
+		}
+
+		for _, entry := range strings.Split(stdOut, "\x00") {
+			key, value, _ := strings.Cut(entry, "\n")
+			if key == "" || !isRiskyConfig(key, value) {
+				continue
+			}
+			found[key] = true
+		}
+	}
+
+	riskyKeys := make([]string, 0, len(found))
+	for key := range found {
+		riskyKeys = append(riskyKeys, key)
+	}
+	sort.Strings(riskyKeys)
+	return riskyKeys, nil
+}
+
+func isRiskyConfig(key, value string) bool {
+	key = strings.ToLower(key)
+	if key == "core.fsmonitor" && strings.EqualFold(value, "false") {
+		return false
+	}
+
+	section, rest, _ := strings.Cut(key, ".")
+	name := rest
+	subsection := ""
+	if idx := strings.LastIndex(rest, "."); idx >= 0 {
+		subsection, name = rest[:idx], rest[idx+1:]
+	}
+
+	pattern := key
+	if subsection != "" {
+		pattern = section + ".*." + name
+	}
+	for _, risky := range riskyConfigPatterns {
+		if risky != pattern {
+			continue
+		}
+		if prefix, ok := riskyConfigValuePrefixes[pattern]; ok {
+			return strings.HasPrefix(strings.TrimSpace(value), prefix)
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestFindRiskyConfig(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		risky bool
+	}{
+		{"core.fsmonitor", "/tmp/cmd", true},
+		{"core.fsmonitor", "false", false},
+		{"core.sshCommand", "cmd", true},
+		{"core.hooksPath", "/tmp/hooks", true},
+		{"core.gitProxy", "cmd", true},
+		{"core.askPass", "cmd", true},
+		{"core.pager", "cmd", true},
+		{"core.editor", "cmd", true},
+		{"core.alternateRefsCommand", "cmd", true},
+		{"core.worktree", "/tmp", true},
+		{"sequence.editor", "cmd", true},
+		{"diff.external", "cmd", true},
+		{"diff.tool.textconv", "cmd", true},
+		{"diff.tool.command", "cmd", true},
+		{"merge.tool.driver", "cmd", true},
+		{"filter.lfs.clean", "cmd", true},
+		{"filter.lfs.smudge", "cmd", true},
+		{"filter.lfs.process", "cmd", true},
+		{"credential.helper", "cmd", true},
+		{"credential.https://example.com.helper", "cmd", true},
+		{"gpg.program", "cmd", true},
+		{"gpg.ssh.program", "cmd", true},
+		{"gpg.ssh.defaultKeyCommand", "cmd", true},
+		{"include.path", "/tmp/config", true},
+		{"includeIf.gitdir:/tmp/.path", "/tmp/config", true},
+		{"submodule.lib.update", "!cmd", true},
+		{"submodule.lib.update", "rebase", false},
+		{"remote.origin.uploadpack", "cmd", true},
+		{"remote.origin.receivepack", "cmd", true},
+		{"remote.origin.vcs", "cmd", true},
+		{"uploadpack.packObjectsHook", "cmd", true},
+		{"protocol.allow", "always", true},
+		{"protocol.ext.allow", "always", true},
+		{"url.ext::cmd.insteadOf", "https://example.com", true},
+		{"url.ext::cmd.pushInsteadOf", "https://example.com", true},
+		{"user.name", "Test", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key+"="+test.value, func(t *testing.T) {
+			git, dir := newTestRepository(t)
+			runTestGit(t, dir, "config", test.key, test.value)
+
+			keys, err := git.FindRiskyConfig()
+			if err != nil {
+				t.Fatalf("FindRiskyConfig: %s", err)
+			}
+			if got := len(keys) > 0; got != test.risky {
+				t.Fatalf("expected risky %t, got keys %v", test.risky, keys)
+			}
+
+			_, err = git.WithSafeMode(SafeModeOptions{})
+			var unsafeErr *UnsafeRepositoryError
+			if got := errors.As(err, &unsafeErr); got != test.risky {
+				t.Fatalf("expected WithSafeMode to reject %t, got %v", test.risky, err)
+			}
+		})
+	}
+}
+
+func TestSafeModeOverrides(t *testing.T) {
+	git, dir := newTestRepository(t)
+	runTestGit(t, dir, "config", "submodule.lib.update", "rebase")
+
+	safe, err := git.WithSafeMode(SafeModeOptions{})
+	if err != nil {
+		t.Fatalf("WithSafeMode: %s", err)
+	}
+
+	for key, expected := range map[string]string{
+		"submodule.lib.update":      "checkout",
+		"gpg.ssh.defaultKeyCommand": "",
+		"core.hooksPath":            os.DevNull,
+	} {
+		value, err := safe.runGit("config", "--get", key)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", key, err)
+		}
+		if value != expected {
+			t.Errorf("expected %s to be overridden to %q, got %q", key, expected, value)
+		}
+	}
+}
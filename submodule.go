@@ -0,0 +1,162 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const defaultSubmoduleBumpMaxCommits = 50
+
+type BumpSubmoduleOptions struct {
+	// Remote is the remote fetched inside the submodule, defaults to origin
+	Remote string
+	// NoFetch skips fetching the submodule before resolving the target
+	NoFetch bool
+	// Subject overrides the generated first line of the commit message
+	Subject string
+	// MaxCommits caps how many submodule commits are listed in the message
+	MaxCommits int
+}
+
+type SubmoduleCommit struct {
+	Hash    string
+	Subject string
+}
+
+type SubmoduleBump struct {
+	Path    string
+	OldHash string
+	NewHash string
+	Commits []SubmoduleCommit
+	// SuperprojectCommit is the created commit, empty if the submodule
+	// was already at the target
+	SuperprojectCommit string
+}
+
+func (git GitCLIWrapper) submoduleGit(path string, args ...string) (string, error) {
+	return git.runGit(append([]string{"-C", path}, args...)...)
+}
+
+func (git GitCLIWrapper) BumpSubmodule(path, targetRef string, opts BumpSubmoduleOptions) (*SubmoduleBump, error) {
+	git.logger.Debugf("bumping submodule %s to %s", path, targetRef)
+	path = strings.TrimSuffix(path, "/")
+
+	remote := opts.Remote
+	if remote == "" {
+		remote = "origin"
+	}
+	maxCommits := opts.MaxCommits
+	if maxCommits <= 0 {
+		maxCommits = defaultSubmoduleBumpMaxCommits
+	}
+
+	oldHash, err := git.runGit("rev-parse", "HEAD:"+path)
+	if err != nil {
+		git.logger.Warnf("failed to find submodule %s in the superproject", path)
+		return nil, err
+	}
+
+	if _, err := git.runGit("submodule", "update", "--init", "--", path); err != nil {
+		git.logger.Warnf("failed to initialise submodule %s", path)
+		return nil, err
+	}
+
+	if !opts.NoFetch {
+		if _, err := git.submoduleGit(path, "fetch", remote); err != nil {
+			git.logger.Warnf("failed to fetch submodule %s from %s", path, remote)
+			return nil, err
+		}
+	}
+
+	newHash, err := git.submoduleGit(path, "rev-parse", "--verify", "--quiet", remote+"/"+targetRef+"^{commit}")
+	if err != nil {
+		newHash, err = git.submoduleGit(path, "rev-parse", "--verify", "--quiet", targetRef+"^{commit}")
+		if err != nil {
+			git.logger.Warnf("failed to resolve %s in submodule %s", targetRef, path)
+			return nil, err
+		}
+	}
+
+	bump := &SubmoduleBump{
+		Path:    path,
+		OldHash: oldHash,
+		NewHash: newHash,
+	}
+	if oldHash == newHash {
+		git.logger.Infof("submodule %s is already at %s", path, newHash)
+		return bump, nil
+	}
+
+	stdOut, err := git.submoduleGit(path, "log", "--format=%H%x00%s", oldHash+".."+newHash)
+	if err != nil {
+		git.logger.Warnf("failed to list commits in submodule %s", path)
+		return nil, err
+	}
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.SplitN(line, "\x00", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		bump.Commits = append(bump.Commits, SubmoduleCommit{Hash: fields[0], Subject: fields[1]})
+	}
+
+	if _, err := git.submoduleGit(path, "checkout", "--quiet", "--detach", newHash); err != nil {
+		git.logger.Warnf("failed to checkout %s in submodule %s", newHash, path)
+		return nil, err
+	}
+	if _, err := git.runGit("update-index", "--cacheinfo", fmt.Sprintf("160000,%s,%s", newHash, path)); err != nil {
+		git.logger.Warnf("failed to update the submodule pointer for %s", path)
+		return nil, err
+	}
+
+	message := submoduleBumpMessage(bump, opts.Subject, maxCommits)
+	if _, err := git.runGit("commit", "--quiet", "-m", message, "--", path); err != nil {
+		git.logger.Warnf("failed to commit the submodule bump for %s", path)
+		return nil, err
+	}
+
+	commit, err := git.runGit("rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if commit == "" {
+		return nil, errors.New("failed to resolve the submodule bump commit")
+	}
+	bump.SuperprojectCommit = commit
+
+	return bump, nil
+}
+
+func submoduleBumpMessage(bump *SubmoduleBump, subject string, maxCommits int) string {
+	if subject == "" {
+		subject = fmt.Sprintf("Bump %s from %s to %s", bump.Path, shortHash(bump.OldHash), shortHash(bump.NewHash))
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(subject)
+	sb.WriteString("\n")
+
+	if len(bump.Commits) == 0 {
+		sb.WriteString("\nThe new submodule commit is not a descendant of the previous one.\n")
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("\nIncluded commits in %s:\n\n", bump.Path))
+	for idx, commit := range bump.Commits {
+		if idx == maxCommits {
+			sb.WriteString(fmt.Sprintf("... and %d more\n", len(bump.Commits)-maxCommits))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("- %s %s\n", shortHash(commit.Hash), commit.Subject))
+	}
+
+	return sb.String()
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
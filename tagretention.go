@@ -0,0 +1,230 @@
+package gitcliwrapper
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var semverTagPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+type TagRetentionPolicy struct {
+	// KeepPreReleasesPerMinor is how many of the newest pre-release tags are
+	// kept for each major.minor version, zero keeps all of them
+	KeepPreReleasesPerMinor int
+	// NightlyPatterns are glob patterns for nightly tags, these are deleted
+	// once older than NightlyMaxAge
+	NightlyPatterns []string
+	NightlyMaxAge   time.Duration
+	// Protected are glob patterns for tags which are never deleted
+	Protected []string
+	// Now overrides the clock used to age nightly tags
+	Now func() time.Time
+}
+
+type DeletedTag struct {
+	Name   string
+	Hash   string
+	Date   time.Time
+	Reason string
+}
+
+type retentionTag struct {
+	name       string
+	hash       string
+	date       time.Time
+	major      int
+	minor      int
+	patch      int
+	preRelease string
+	semver     bool
+}
+
+func matchesAnyGlob(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// comparePreRelease orders pre-release identifiers following semver
+// precedence rules.
+func comparePreRelease(a, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for idx := 0; idx < len(aParts) && idx < len(bParts); idx++ {
+		aNum, aErr := strconv.Atoi(aParts[idx])
+		bNum, bErr := strconv.Atoi(bParts[idx])
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if cmp := strings.Compare(aParts[idx], bParts[idx]); cmp != 0 {
+				return cmp
+			}
+		}
+	}
+	return len(aParts) - len(bParts)
+}
+
+func (git GitCLIWrapper) listRetentionTags() ([]retentionTag, error) {
+	stdOut, err := git.runGit("for-each-ref", "refs/tags", "--format=%(refname:strip=2)%00%(objectname)%00%(creatordate:iso-strict)")
+	if err != nil {
+		git.logger.Warn("failed to list tags")
+		return nil, err
+	}
+
+	var tags []retentionTag
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 3 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			git.logger.Warnf("date for tag %s came back in an unexpected format", fields[0])
+			return nil, err
+		}
+
+		tag := retentionTag{name: fields[0], hash: fields[1], date: date}
+		if match := semverTagPattern.FindStringSubmatch(tag.name); match != nil {
+			tag.semver = true
+			tag.major, _ = strconv.Atoi(match[1])
+			tag.minor, _ = strconv.Atoi(match[2])
+			tag.patch, _ = strconv.Atoi(match[3])
+			tag.preRelease = match[4]
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+// ApplyTagRetention deletes tags which fall outside the policy, locally and
+// in a single push to the remote, and returns what was deleted. Final
+// releases and tags matching no rule are always kept. With dryRun nothing is
+// deleted and the tags which would be are returned.
+func (git GitCLIWrapper) ApplyTagRetention(policy TagRetentionPolicy, dryRun bool) ([]DeletedTag, error) {
+	git.logger.Debugf("applying tag retention policy, dry run: %t", dryRun)
+
+	tags, err := git.listRetentionTags()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if policy.Now != nil {
+		now = policy.Now()
+	}
+
+	var deleted []DeletedTag
+	preReleases := map[string][]retentionTag{}
+	for _, tag := range tags {
+		if matchesAnyGlob(tag.name, policy.Protected) {
+			continue
+		}
+		if matchesAnyGlob(tag.name, policy.NightlyPatterns) {
+			if policy.NightlyMaxAge > 0 && now.Sub(tag.date) > policy.NightlyMaxAge {
+				deleted = append(deleted, DeletedTag{
+					Name:   tag.name,
+					Hash:   tag.hash,
+					Date:   tag.date,
+					Reason: fmt.Sprintf("nightly tag older than %s", policy.NightlyMaxAge),
+				})
+			}
+			continue
+		}
+		if tag.semver && tag.preRelease != "" {
+			minor := fmt.Sprintf("%d.%d", tag.major, tag.minor)
+			preReleases[minor] = append(preReleases[minor], tag)
+		}
+	}
+
+	if policy.KeepPreReleasesPerMinor > 0 {
+		for minor, minorTags := range preReleases {
+			sort.Slice(minorTags, func(i, j int) bool {
+				if minorTags[i].patch != minorTags[j].patch {
+					return minorTags[i].patch > minorTags[j].patch
+				}
+				if cmp := comparePreRelease(minorTags[i].preRelease, minorTags[j].preRelease); cmp != 0 {
+					return cmp > 0
+				}
+				return minorTags[i].date.After(minorTags[j].date)
+			})
+			for idx := policy.KeepPreReleasesPerMinor; idx < len(minorTags); idx++ {
+				deleted = append(deleted, DeletedTag{
+					Name:   minorTags[idx].name,
+					Hash:   minorTags[idx].hash,
+					Date:   minorTags[idx].date,
+					Reason: fmt.Sprintf("beyond the newest %d pre-releases of %s", policy.KeepPreReleasesPerMinor, minor),
+				})
+			}
+		}
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Name < deleted[j].Name
+	})
+	if dryRun || len(deleted) == 0 {
+		return deleted, nil
+	}
+
+	if err := git.deleteTags(deleted); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (git GitCLIWrapper) deleteTags(tags []DeletedTag) error {
+	if git.remote != "" {
+		// listed through ListRemoteRefs so the tags are those of the namespace
+		// the deletes are pushed to
+		names, err := git.ListRemoteRefs("tags")
+		if err != nil {
+			git.logger.Warnf("failed to list tags on remote %s", git.remote)
+			return err
+		}
+		remoteTags := map[string]bool{}
+		for _, name := range names {
+			remoteTags[name] = true
+		}
+
+		var refSpecs []string
+		for _, tag := range tags {
+			if remoteTags[tag.Name] {
+				refSpecs = append(refSpecs, ":refs/tags/"+tag.Name)
+			}
+		}
+		if len(refSpecs) > 0 {
+			if err := git.Push(refSpecs, PushOptions{}); err != nil {
+				git.logger.Warnf("failed to delete tags on remote %s", git.remote)
+				return err
+			}
+		}
+	}
+
+	args := []string{"tag", "--delete"}
+	for _, tag := range tags {
+		args = append(args, tag.Name)
+	}
+	if _, err := git.runGit(args...); err != nil {
+		git.logger.Warn("failed to delete local tags")
+		return err
+	}
+
+	return nil
+}
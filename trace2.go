@@ -0,0 +1,210 @@
+package gitcliwrapper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type TraceRegion struct {
+	// Process is the trace2 session id, child processes have ids nested
+	// under their parent
+	Process  string
+	Category string
+	Label    string
+	Nesting  int
+	Elapsed  time.Duration
+}
+
+type TraceChildProcess struct {
+	Argv     []string
+	ExitCode int
+	Elapsed  time.Duration
+}
+
+type TraceData struct {
+	Process  string
+	Category string
+	Key      string
+	Value    string
+}
+
+type PerformanceSummary struct {
+	Args       []string
+	Subcommand string
+	ExitCode   int
+	// Elapsed is the wall clock time measured by the wrapper
+	Elapsed time.Duration
+	// GitElapsed is the runtime git itself reported on exit
+	GitElapsed     time.Duration
+	Regions        []TraceRegion
+	ChildProcesses []TraceChildProcess
+	Data           []TraceData
+}
+
+type SubcommandMetrics struct {
+	Calls    int
+	Failures int
+	Elapsed  time.Duration
+}
+
+type PerformanceMetrics struct {
+	Calls        int
+	Elapsed      time.Duration
+	BySubcommand map[string]SubcommandMetrics
+}
+
+type trace2Event struct {
+	Event    string          `json:"event"`
+	SID      string          `json:"sid"`
+	TRel     float64         `json:"t_rel"`
+	TAbs     float64         `json:"t_abs"`
+	Code     int             `json:"code"`
+	Category string          `json:"category"`
+	Label    string          `json:"label"`
+	Nesting  int             `json:"nesting"`
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value"`
+	ChildID  int             `json:"child_id"`
+	Argv     []string        `json:"argv"`
+}
+
+type trace2Recorder struct {
+	mu      sync.Mutex
+	onCall  func(PerformanceSummary)
+	metrics PerformanceMetrics
+}
+
+// WithTrace2 returns a copy of the wrapper which runs every git call with
+// trace2 event tracing. A performance summary is passed to onCall after each
+// call, attached to any CommandError, and aggregated into PerformanceMetrics.
+func (git GitCLIWrapper) WithTrace2(onCall func(PerformanceSummary)) *GitCLIWrapper {
+	git.tracer = &trace2Recorder{
+		onCall: onCall,
+		metrics: PerformanceMetrics{
+			BySubcommand: map[string]SubcommandMetrics{},
+		},
+	}
+	return &git
+}
+
+func (git GitCLIWrapper) PerformanceMetrics() PerformanceMetrics {
+	if git.tracer == nil {
+		return PerformanceMetrics{BySubcommand: map[string]SubcommandMetrics{}}
+	}
+
+	git.tracer.mu.Lock()
+	defer git.tracer.mu.Unlock()
+
+	metrics := git.tracer.metrics
+	metrics.BySubcommand = map[string]SubcommandMetrics{}
+	for subcommand, subcommandMetrics := range git.tracer.metrics.BySubcommand {
+		metrics.BySubcommand[subcommand] = subcommandMetrics
+	}
+	return metrics
+}
+
+func createTraceFile() (string, error) {
+	file, err := os.CreateTemp("", "gitcliwrapper-trace2-*.json")
+	if err != nil {
+		return "", err
+	}
+	path := file.Name()
+	return path, file.Close()
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func (recorder *trace2Recorder) record(l logger, tracePath string, args []string, elapsed time.Duration, exitCode int) *PerformanceSummary {
+	summary := PerformanceSummary{
+		Args:       args,
+		Subcommand: gitSubcommand(args),
+		ExitCode:   exitCode,
+		Elapsed:    elapsed,
+	}
+
+	data, err := os.ReadFile(tracePath)
+	if err != nil {
+		l.Warnf("failed to read trace2 output: %s", err)
+	} else if err := summary.parseTrace2Events(data); err != nil {
+		l.Warnf("failed to parse trace2 output: %s", err)
+	}
+
+	recorder.mu.Lock()
+	recorder.metrics.Calls++
+	recorder.metrics.Elapsed += elapsed
+	subcommandMetrics := recorder.metrics.BySubcommand[summary.Subcommand]
+	subcommandMetrics.Calls++
+	subcommandMetrics.Elapsed += elapsed
+	if exitCode != 0 {
+		subcommandMetrics.Failures++
+	}
+	recorder.metrics.BySubcommand[summary.Subcommand] = subcommandMetrics
+	recorder.mu.Unlock()
+
+	if recorder.onCall != nil {
+		recorder.onCall(summary)
+	}
+
+	return &summary
+}
+
+func (summary *PerformanceSummary) parseTrace2Events(data []byte) error {
+	mainSID := ""
+	childIndex := map[string]int{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event trace2Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return err
+		}
+		if mainSID == "" {
+			mainSID = event.SID
+		}
+
+		switch event.Event {
+		case "exit":
+			if event.SID == mainSID {
+				summary.GitElapsed = secondsToDuration(event.TAbs)
+			}
+		case "region_leave":
+			summary.Regions = append(summary.Regions, TraceRegion{
+				Process:  event.SID,
+				Category: event.Category,
+				Label:    event.Label,
+				Nesting:  event.Nesting,
+				Elapsed:  secondsToDuration(event.TRel),
+			})
+		case "child_start":
+			childIndex[fmt.Sprintf("%s/%d", event.SID, event.ChildID)] = len(summary.ChildProcesses)
+			summary.ChildProcesses = append(summary.ChildProcesses, TraceChildProcess{Argv: event.Argv})
+		case "child_exit":
+			if idx, ok := childIndex[fmt.Sprintf("%s/%d", event.SID, event.ChildID)]; ok {
+				summary.ChildProcesses[idx].ExitCode = event.Code
+				summary.ChildProcesses[idx].Elapsed = secondsToDuration(event.TRel)
+			}
+		case "data", "data_json":
+			value := string(event.Value)
+			var stringValue string
+			if err := json.Unmarshal(event.Value, &stringValue); err == nil {
+				value = stringValue
+			}
+			summary.Data = append(summary.Data, TraceData{
+				Process:  event.SID,
+				Category: event.Category,
+				Key:      event.Key,
+				Value:    value,
+			})
+		}
+	}
+
+	return scanner.Err()
+}
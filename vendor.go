@@ -0,0 +1,222 @@
+package gitcliwrapper
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	vendorDirTrailer    = "Vendor-Dir"
+	vendorSourceTrailer = "Vendor-Source"
+	vendorRefTrailer    = "Vendor-Ref"
+	vendorCommitTrailer = "Vendor-Commit"
+	vendorSquashTrailer = "Vendor-Squash"
+)
+
+type vendorImport struct {
+	commit       string
+	importParent string
+	source       string
+	ref          string
+	sourceCommit string
+	squash       bool
+}
+
+// stripCredentials removes any user info from a remote URL, so a token used
+// to fetch never ends up recorded in history or metadata.
+func stripCredentials(remoteURL string) string {
+	parsed, err := url.Parse(remoteURL)
+	if err != nil || parsed.User == nil || parsed.Scheme == "" {
+		return remoteURL
+	}
+	if parsed.Scheme == "ssh" {
+		if _, hasPassword := parsed.User.Password(); !hasPassword {
+			return remoteURL
+		}
+		parsed.User = url.User(parsed.User.Username())
+		return parsed.String()
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+func (git GitCLIWrapper) fetchVendorSource(sourceRepo, ref string) (string, error) {
+	if _, err := git.runGit("fetch", "--no-tags", sourceRepo, ref); err != nil {
+		git.logger.Warnf("failed to fetch %s from %s", ref, stripCredentials(sourceRepo))
+		return "", err
+	}
+	return git.runGit("rev-parse", "--verify", "FETCH_HEAD^{commit}")
+}
+
+func vendorTrailers(prefix, sourceRepo, ref, sourceCommit string, squash bool) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s: %s\n", vendorDirTrailer, prefix))
+	sb.WriteString(fmt.Sprintf("%s: %s\n", vendorSourceTrailer, stripCredentials(sourceRepo)))
+	sb.WriteString(fmt.Sprintf("%s: %s\n", vendorRefTrailer, ref))
+	sb.WriteString(fmt.Sprintf("%s: %s\n", vendorCommitTrailer, sourceCommit))
+	if squash {
+		sb.WriteString(fmt.Sprintf("%s: true\n", vendorSquashTrailer))
+	}
+	return sb.String()
+}
+
+func (git GitCLIWrapper) createVendorSquash(prefix, sourceCommit string, parent string) (string, error) {
+	message := fmt.Sprintf("Squashed '%s/' content from commit %s\n\n%s: %s\n", prefix, shortHash(sourceCommit), vendorCommitTrailer, sourceCommit)
+	args := []string{"commit-tree", sourceCommit + "^{tree}", "-m", message}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	squashCommit, err := git.runGit(args...)
+	if err != nil {
+		git.logger.Warnf("failed to create squashed commit for %s", prefix)
+		return "", err
+	}
+	return squashCommit, nil
+}
+
+func (git GitCLIWrapper) VendorAdd(prefix, sourceRepo, ref string, squash bool) (*string, error) {
+	prefix = strings.Trim(prefix, "/")
+	git.logger.Debugf("vendoring %s from %s into %s", ref, stripCredentials(sourceRepo), prefix)
+	if prefix == "" {
+		return nil, errors.New("a vendor prefix is required")
+	}
+
+	if _, err := git.runGit("cat-file", "-e", "HEAD:"+prefix); err == nil {
+		return nil, fmt.Errorf("prefix %s already exists in HEAD", prefix)
+	}
+
+	sourceCommit, err := git.fetchVendorSource(sourceRepo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	importParent := sourceCommit
+	if squash {
+		importParent, err = git.createVendorSquash(prefix, sourceCommit, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := git.runGit("read-tree", "--prefix="+prefix+"/", "-u", importParent); err != nil {
+		git.logger.Warnf("failed to read the vendored tree into %s", prefix)
+		return nil, err
+	}
+	tree, err := git.runGit("write-tree")
+	if err != nil {
+		return nil, err
+	}
+	head, err := git.runGit("rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Add '%s/' from %s %s\n\n%s", prefix, stripCredentials(sourceRepo), ref, vendorTrailers(prefix, sourceRepo, ref, sourceCommit, squash))
+	commit, err := git.runGit("commit-tree", tree, "-p", head, "-p", importParent, "-m", message)
+	if err != nil {
+		git.logger.Warnf("failed to create the vendor commit for %s", prefix)
+		return nil, err
+	}
+	if _, err := git.runGit("update-ref", "-m", "vendor add "+prefix, "HEAD", commit, head); err != nil {
+		git.logger.Warnf("failed to move HEAD to the vendor commit for %s", prefix)
+		return nil, err
+	}
+
+	return &commit, nil
+}
+
+func (git GitCLIWrapper) lastVendorImport(prefix string) (*vendorImport, error) {
+	stdOut, err := git.runGit("log", "--first-parent", "--fixed-strings", "--grep="+vendorDirTrailer+": "+prefix,
+		"--format=%H %P%x00%(trailers:only,unfold)%x01")
+	if err != nil {
+		git.logger.Warnf("failed to look up previous vendor imports for %s", prefix)
+		return nil, err
+	}
+
+	for _, entry := range strings.Split(stdOut, "\x01") {
+		fields := strings.SplitN(strings.TrimSpace(entry), "\x00", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		trailers := parseTrailers(fields[1])
+		if trailers[vendorDirTrailer] != prefix {
+			continue
+		}
+		hashes := strings.Fields(fields[0])
+		if len(hashes) < 3 {
+			continue
+		}
+		return &vendorImport{
+			commit:       hashes[0],
+			importParent: hashes[2],
+			source:       trailers[vendorSourceTrailer],
+			ref:          trailers[vendorRefTrailer],
+			sourceCommit: trailers[vendorCommitTrailer],
+			squash:       trailers[vendorSquashTrailer] == "true",
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no previous vendor import found for %s", prefix)
+}
+
+func parseTrailers(text string) map[string]string {
+	trailers := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		trailers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return trailers
+}
+
+// VendorUpdate returns a nil commit when the vendored directory is already
+// at the requested ref.
+func (git GitCLIWrapper) VendorUpdate(prefix, ref string) (*string, error) {
+	prefix = strings.Trim(prefix, "/")
+	git.logger.Debugf("updating vendored directory %s", prefix)
+
+	previous, err := git.lastVendorImport(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if ref == "" {
+		ref = previous.ref
+	}
+
+	sourceCommit, err := git.fetchVendorSource(previous.source, ref)
+	if err != nil {
+		return nil, err
+	}
+	if sourceCommit == previous.sourceCommit {
+		git.logger.Infof("vendored directory %s is already at %s", prefix, sourceCommit)
+		return nil, nil
+	}
+
+	importParent := sourceCommit
+	if previous.squash {
+		importParent, err = git.createVendorSquash(prefix, sourceCommit, previous.importParent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	message := fmt.Sprintf("Update '%s/' from %s %s\n\n%s", prefix, previous.source, ref, vendorTrailers(prefix, previous.source, ref, sourceCommit, previous.squash))
+	if _, err := git.runGit("merge", "--no-ff", "--allow-unrelated-histories", "-Xsubtree="+prefix, "-m", message, importParent); err != nil {
+		git.logger.Warnf("failed to merge the vendor update into %s, aborting", prefix)
+		if _, abortErr := git.runGit("merge", "--abort"); abortErr != nil {
+			git.logger.Warn("failed to abort the vendor merge")
+		}
+		return nil, err
+	}
+
+	commit, err := git.runGit("rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	return &commit, nil
+}